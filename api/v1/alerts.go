@@ -35,7 +35,6 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
-	"github.com/oklog/ulid/v2"
 	"github.com/whawty/alerts/store"
 )
 
@@ -61,12 +60,16 @@ func (api *API) CreateAlert(c *gin.Context) {
 		return
 	}
 	alert.State = store.StateNew
-	alert.ID = ulid.Make().String()
 
-	if alert, err = api.store.CreateAlert(alert); err != nil {
+	alert, created, err := api.store.CreateAlert(alert)
+	if err != nil {
 		sendError(c, err)
 		return
 	}
+	if !created {
+		c.JSON(http.StatusOK, alert)
+		return
+	}
 	c.JSON(http.StatusCreated, alert)
 }
 
@@ -90,7 +93,12 @@ func (api *API) UpdateAlertState(c *gin.Context) {
 		return
 	}
 
-	alert, err := api.store.SetAlertState(id, state)
+	actor := c.Query("actor")
+	if actor == "" {
+		actor = c.ClientIP()
+	}
+
+	alert, err := api.store.SetAlertState(id, state, actor, store.SourceAPI)
 	if err != nil {
 		sendError(c, err)
 		return
@@ -98,6 +106,28 @@ func (api *API) UpdateAlertState(c *gin.Context) {
 	c.JSON(http.StatusOK, alert)
 }
 
+func (api *API) ReadAlertHistory(c *gin.Context) {
+	id := c.Param("alert-id")
+
+	history, err := api.store.GetAlertHistory(id)
+	if err != nil {
+		sendError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, AlertHistoryListing{history})
+}
+
+func (api *API) ReadAlertDeliveries(c *gin.Context) {
+	id := c.Param("alert-id")
+
+	deliveries, err := api.store.GetAlertDeliveries(id)
+	if err != nil {
+		sendError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, AlertDeliveriesListing{deliveries})
+}
+
 func (api *API) DeleteAlert(c *gin.Context) {
 	id := c.Param("alert-id")
 
@@ -36,17 +36,22 @@ import (
 )
 
 type API struct {
+	conf  *Config
 	store *store.Store
 }
 
-func NewAPI(st *store.Store) (api *API) {
+func NewAPI(conf *Config, st *store.Store) (api *API) {
 	api = &API{}
+	api.conf = conf
+	if api.conf == nil {
+		api.conf = &Config{}
+	}
 	api.store = st
 	return
 }
 
-func InstallHTTPHandler(r *gin.RouterGroup, st *store.Store) {
-	api := NewAPI(st)
+func InstallHTTPHandler(r *gin.RouterGroup, conf *Config, st *store.Store) {
+	api := NewAPI(conf, st)
 
 	// Shows
 	alerts := r.Group("alerts")
@@ -55,6 +60,8 @@ func InstallHTTPHandler(r *gin.RouterGroup, st *store.Store) {
 		alerts.POST("", api.CreateAlert)
 		alerts.GET(":alert-id", api.ReadAlert)
 		alerts.PATCH(":alert-id/state", api.UpdateAlertState)
+		alerts.GET(":alert-id/history", api.ReadAlertHistory)
+		alerts.GET(":alert-id/deliveries", api.ReadAlertDeliveries)
 		alerts.DELETE(":alert-id", api.DeleteAlert)
 	}
 	heartbeats := r.Group("heartbeats")
@@ -62,7 +69,17 @@ func InstallHTTPHandler(r *gin.RouterGroup, st *store.Store) {
 		heartbeats.GET("", api.ListHeartbeats)
 		heartbeats.POST("", api.CreateHeartbeat)
 		heartbeats.GET(":heartbeat-id", api.ReadHeartbeat)
-		heartbeats.DELETE(":heartbeat-id", api.DeleteAlert)
+		heartbeats.POST(":heartbeat-id/refresh", api.RefreshHeartbeat)
+		heartbeats.GET(":heartbeat-id/refresh", api.RefreshHeartbeat)
+		heartbeats.DELETE(":heartbeat-id", api.DeleteHeartbeat)
+	}
+	silences := r.Group("silences")
+	{
+		silences.GET("", api.ListSilences)
+		silences.POST("", api.CreateSilence)
+		silences.GET(":silence-id", api.ReadSilence)
+		silences.PUT(":silence-id", api.UpdateSilence)
+		silences.DELETE(":silence-id", api.DeleteSilence)
 	}
 
 	submit := r.Group("submit")
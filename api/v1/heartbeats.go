@@ -35,7 +35,6 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
-	"github.com/oklog/ulid/v2"
 	"github.com/whawty/alerts/store"
 )
 
@@ -60,7 +59,14 @@ func (api *API) CreateHeartbeat(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "error decoding heartbeat: " + err.Error()})
 		return
 	}
-	heartbeat.ID = ulid.Make().String()
+	if heartbeat.Interval <= 0 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "heartbeat interval must be > 0"})
+		return
+	}
+	if heartbeat.GracePeriod < 0 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "heartbeat grace period must be >= 0"})
+		return
+	}
 
 	if heartbeat, err = api.store.CreateHeartbeat(heartbeat); err != nil {
 		sendError(c, err)
@@ -80,6 +86,17 @@ func (api *API) ReadHeartbeat(c *gin.Context) {
 	c.JSON(http.StatusOK, heartbeat)
 }
 
+func (api *API) RefreshHeartbeat(c *gin.Context) {
+	id := c.Param("heartbeat-id")
+
+	heartbeat, err := api.store.RefreshHeartbeat(id)
+	if err != nil {
+		sendError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, heartbeat)
+}
+
 func (api *API) DeleteHeartbeat(c *gin.Context) {
 	id := c.Param("heartbeat-id")
 
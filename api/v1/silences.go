@@ -0,0 +1,128 @@
+//
+// Copyright (c) 2023 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.alerts nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/whawty/alerts/store"
+)
+
+func decodeSilence(c *gin.Context) (*store.Silence, bool) {
+	silence := &store.Silence{}
+	if err := json.NewDecoder(c.Request.Body).Decode(silence); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "error decoding silence: " + err.Error()})
+		return nil, false
+	}
+	if len(silence.Matchers) == 0 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "silence must have at least one matcher"})
+		return nil, false
+	}
+	if silence.StartsAt.IsZero() {
+		silence.StartsAt = time.Now()
+	}
+	if !silence.EndsAt.After(silence.StartsAt) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "silence must end after it starts"})
+		return nil, false
+	}
+	return silence, true
+}
+
+func (api *API) ListSilences(c *gin.Context) {
+	offset, limit, ok := getPaginationParameter(c)
+	if !ok {
+		return
+	}
+
+	silences, err := api.store.ListSilences(offset, limit)
+	if err != nil {
+		sendError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, SilencesListing{silences})
+}
+
+func (api *API) CreateSilence(c *gin.Context) {
+	silence, ok := decodeSilence(c)
+	if !ok {
+		return
+	}
+	if silence.CreatedBy == "" {
+		silence.CreatedBy = c.ClientIP()
+	}
+
+	silence, err := api.store.CreateSilence(silence)
+	if err != nil {
+		sendError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, silence)
+}
+
+func (api *API) ReadSilence(c *gin.Context) {
+	id := c.Param("silence-id")
+
+	silence, err := api.store.GetSilence(id)
+	if err != nil {
+		sendError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, silence)
+}
+
+func (api *API) UpdateSilence(c *gin.Context) {
+	id := c.Param("silence-id")
+
+	update, ok := decodeSilence(c)
+	if !ok {
+		return
+	}
+
+	silence, err := api.store.UpdateSilence(id, update)
+	if err != nil {
+		sendError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, silence)
+}
+
+func (api *API) DeleteSilence(c *gin.Context) {
+	id := c.Param("silence-id")
+
+	if err := api.store.DeleteSilence(id); err != nil {
+		sendError(c, err)
+		return
+	}
+	c.JSON(http.StatusNoContent, nil)
+}
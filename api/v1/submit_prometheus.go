@@ -36,13 +36,53 @@ import (
 
 	"github.com/gin-gonic/gin"
 	amWebhook "github.com/prometheus/alertmanager/notify/webhook"
+	amTemplate "github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/common/model"
 	"github.com/whawty/alerts/store"
 )
 
-func alertFromPrometheusAlertmanagerMessage(msg *amWebhook.Message) *store.Alert {
-	// TODO: implement this
+const (
+	prometheusAlertSource   = "prometheus"
+	prometheusResolvedActor = "prometheus-alertmanager"
+)
+
+var (
+	defaultPrometheusSeverityMapping = map[string]store.AlertSeverity{
+		"critical":      store.SeverityCritical,
+		"warning":       store.SeverityWarning,
+		"info":          store.SeverityInformational,
+		"informational": store.SeverityInformational,
+		"none":          store.SeverityInformational,
+	}
+)
 
-	return &store.Alert{}
+func (api *API) prometheusSeverity(value string) store.AlertSeverity {
+	if severity, exists := api.conf.Prometheus.SeverityMapping[value]; exists {
+		return severity
+	}
+	if severity, exists := defaultPrometheusSeverityMapping[value]; exists {
+		return severity
+	}
+	if api.conf.Prometheus.DefaultSeverity != nil {
+		return *api.conf.Prometheus.DefaultSeverity
+	}
+	return store.SeverityWarning
+}
+
+func (api *API) alertFromPrometheusAlertmanagerAlert(a *amTemplate.Alert) *store.Alert {
+	alert := &store.Alert{State: store.StateNew, Source: prometheusAlertSource}
+	alert.Name = a.Labels["alertname"]
+	alert.Severity = api.prometheusSeverity(a.Labels["severity"])
+	alert.Description = a.Annotations["description"]
+	alert.Labels = make(map[string]string)
+	for name, value := range a.Labels {
+		alert.Labels[name] = value
+	}
+	alert.Annotations = make(map[string]string)
+	for name, value := range a.Annotations {
+		alert.Annotations[name] = value
+	}
+	return alert
 }
 
 func (api *API) SubmitPrometheus(c *gin.Context) {
@@ -52,11 +92,36 @@ func (api *API) SubmitPrometheus(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "error decoding prometheus-alertmanager message: " + err.Error()})
 		return
 	}
-
-	alert := alertFromPrometheusAlertmanagerMessage(msg)
-	if alert, err = api.store.CreateAlert(alert); err != nil {
-		sendError(c, err)
+	if msg.Data == nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "prometheus-alertmanager message contains no alerts"})
 		return
 	}
+
+	for _, a := range msg.Alerts {
+		alert := api.alertFromPrometheusAlertmanagerAlert(&a)
+		if a.Status != string(model.AlertResolved) {
+			if _, _, err = api.store.CreateAlert(alert); err != nil {
+				sendError(c, err)
+				return
+			}
+			continue
+		}
+
+		existing, err := api.store.GetAlertByFingerprint(alert.ComputeFingerprint())
+		if err != nil {
+			if err == store.ErrNotFound {
+				continue
+			}
+			sendError(c, err)
+			return
+		}
+		if existing.State == store.StateClosed {
+			continue
+		}
+		if _, err = api.store.SetAlertState(existing.ID, store.StateClosed, prometheusResolvedActor, store.SourceAPI); err != nil {
+			sendError(c, err)
+			return
+		}
+	}
 	c.JSON(http.StatusCreated, nil)
 }
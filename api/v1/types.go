@@ -34,6 +34,17 @@ import (
 	"github.com/whawty/alerts/store"
 )
 
+// Configuration
+
+type PrometheusConfig struct {
+	SeverityMapping map[string]store.AlertSeverity `yaml:"severityMapping"`
+	DefaultSeverity *store.AlertSeverity           `yaml:"defaultSeverity"`
+}
+
+type Config struct {
+	Prometheus PrometheusConfig `yaml:"prometheus"`
+}
+
 // common
 type ErrorResponse struct {
 	Error  string      `json:"error,omitempty"`
@@ -45,7 +56,20 @@ type AlertsListing struct {
 	Alerts []store.Alert `json:"results"`
 }
 
+type AlertHistoryListing struct {
+	History []store.AlertStateChange `json:"results"`
+}
+
+type AlertDeliveriesListing struct {
+	Deliveries []store.Delivery `json:"results"`
+}
+
 // Heartbeats
 type HeartbeatsListing struct {
 	Heartbeats []store.Heartbeat `json:"results"`
 }
+
+// Silences
+type SilencesListing struct {
+	Silences []store.Silence `json:"results"`
+}
@@ -35,6 +35,7 @@ import (
 	"os"
 
 	"github.com/spreadspace/tlsconfig"
+	apiV1 "github.com/whawty/alerts/api/v1"
 	"github.com/whawty/alerts/notifier"
 	"github.com/whawty/alerts/store"
 	"gopkg.in/yaml.v3"
@@ -42,6 +43,7 @@ import (
 
 type WebConfig struct {
 	TLS *tlsconfig.TLSConfig `yaml:"tls"`
+	API apiV1.Config         `yaml:"api"`
 }
 
 type Config struct {
@@ -56,7 +56,11 @@ func runWeb(listener net.Listener, config *WebConfig, st *store.Store) (err erro
 	r.GET("/", func(c *gin.Context) { c.Redirect(http.StatusSeeOther, WebUIPathPrefix) })
 	r.StaticFS(WebUIPathPrefix, ui.Assets)
 
-	apiV1.InstallHTTPHandler(r.Group(WebAPIv1Prefix), st)
+	var apiConfig *apiV1.Config
+	if config != nil {
+		apiConfig = &config.API
+	}
+	apiV1.InstallHTTPHandler(r.Group(WebAPIv1Prefix), apiConfig, st)
 
 	server := &http.Server{Handler: r, WriteTimeout: 60 * time.Second, ReadTimeout: 60 * time.Second}
 	if config != nil && config.TLS != nil {
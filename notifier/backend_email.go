@@ -5,16 +5,16 @@
 // Redistribution and use in source and binary forms, with or without
 // modification, are permitted provided that the following conditions are met:
 //
-//   - Redistributions of source code must retain the above copyright notice, this
-//     list of conditions and the following disclaimer.
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
 //
-//   - Redistributions in binary form must reproduce the above copyright notice,
-//     this list of conditions and the following disclaimer in the documentation
-//     and/or other materials provided with the distribution.
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
 //
-//   - Neither the name of whawty.alerts nor the names of its
-//     contributors may be used to endorse or promote products derived from
-//     this software without specific prior written permission.
+// * Neither the name of whawty.alerts nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
 //
 // THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
 // AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
@@ -31,24 +31,70 @@
 package notifier
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"log"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strings"
 	"sync"
+	"time"
 
-	"github.com/whawty/alerts/store"
+	"github.com/flosch/pongo2/v6"
+	"github.com/oklog/ulid/v2"
+)
+
+const (
+	defaultEMailSubjectTemplate = "{% autoescape off %}{% if alerts|length == 1 %}{{ alert.State.Emoji() }} [{{ alert.Severity }}] {{ alert.Name }}{% elif alerts %}{{ alerts|length }} alerts{% for c in notification.SeverityCounts() %} | {{ c.Severity.Emoji() }} {{ c.Count }} {{ c.Severity }}{% endfor %}{% else %}{{ notification.Suppressed }} alerts suppressed by rate limit{% endif %}{% endautoescape %}"
+	defaultEMailTemplate        = `{% autoescape off %}{% for alert in alerts %}{% if not forloop.First %}
+----------------------------------------
+
+{% endif %}Alert:     {{ alert.Name }}
+State:     {{ alert.State.Emoji() }} {{ alert.State }}
+Severity:  {{ alert.Severity.Emoji() }} {{ alert.Severity }}
+Created:   {{ alert.CreatedAt|time:"2006-01-02 15:04:05 MST" }}
+ID:        {{ alert.ID }}
+{% if alert.Source %}Source:    {{ alert.Source }}
+{% endif %}{% if alert.Description %}
+{{ alert.Description }}
+{% endif %}{% if alert.Labels %}
+Labels:
+{% for name, value in alert.Labels sorted %}  {{ name }}: {{ value }}
+{% endfor %}{% endif %}{% if alert.Annotations %}
+Annotations:
+{% for name, value in alert.Annotations sorted %}  {{ name }}: {{ value }}
+{% endfor %}{% endif %}{% endfor %}{% if notification.Suppressed %}{% if alerts %}
+{% endif %}{{ notification.Suppressed }} more alerts have been suppressed by rate limits.
+{% endif %}{% endautoescape %}`
 )
 
 type EMailBackend struct {
-	infoLog *log.Logger
-	dbgLog  *log.Logger
-	name    string
-	conf    *NotifierBackendConfigEMail
-	// TODO: add client config
-	mutex *sync.RWMutex
+	infoLog   *log.Logger
+	dbgLog    *log.Logger
+	name      string
+	conf      *NotifierBackendConfigEMail
+	host      string
+	addr      string
+	from      *mail.Address
+	tlsConfig *tls.Config
+	auth      smtp.Auth
+	subject   *pongo2.Template
+	body      *pongo2.Template
+	mutex     *sync.RWMutex
 }
 
 func NewEMailBackend(name string, conf *NotifierBackendConfigEMail, infoLog, dbgLog *log.Logger) *EMailBackend {
+	if conf.Timeout <= 0 {
+		conf.Timeout = 30 * time.Second
+	}
 	return &EMailBackend{name: name, conf: conf, infoLog: infoLog, dbgLog: dbgLog, mutex: &sync.RWMutex{}}
 }
 
@@ -56,12 +102,126 @@ func (emb *EMailBackend) Init() (err error) {
 	emb.mutex.Lock()
 	defer emb.mutex.Unlock()
 
-	return fmt.Errorf("not yet implemented!")
+	emb.tlsConfig = nil
+	if emb.from, err = mail.ParseAddress(emb.conf.From); err != nil {
+		return fmt.Errorf("invalid from address: %v", err)
+	}
+
+	emb.host, emb.addr = emb.conf.Smarthost, emb.conf.Smarthost
+	if host, _, err := net.SplitHostPort(emb.conf.Smarthost); err == nil {
+		emb.host = host
+	} else if emb.conf.TLSMode == EMailTLSImplicit {
+		emb.addr = net.JoinHostPort(emb.conf.Smarthost, "465")
+	} else {
+		emb.addr = net.JoinHostPort(emb.conf.Smarthost, "25")
+	}
+
+	emb.auth = nil
+	if emb.conf.Auth != nil {
+		switch strings.ToLower(emb.conf.Auth.Mechanism) {
+		case "", "plain":
+			emb.auth = smtp.PlainAuth("", emb.conf.Auth.Username, emb.conf.Auth.Password, emb.host)
+		case "login":
+			emb.auth = &loginAuth{username: emb.conf.Auth.Username, password: emb.conf.Auth.Password, host: emb.host}
+		default:
+			return fmt.Errorf("unsupported authentication mechanism: '%s'", emb.conf.Auth.Mechanism)
+		}
+	}
+
+	subject := emb.conf.SubjectTemplate
+	if subject == "" {
+		subject = defaultEMailSubjectTemplate
+	}
+	if emb.subject, err = pongo2.FromString(subject); err != nil {
+		return fmt.Errorf("failed to parse subject template: %v", err)
+	}
+	body := emb.conf.Template
+	if body == "" {
+		body = defaultEMailTemplate
+	}
+	if emb.body, err = pongo2.FromString(body); err != nil {
+		return fmt.Errorf("failed to parse template: %v", err)
+	}
+
+	tlsConfig, err := emb.conf.TLS.ToGoTLSConfig(emb.host)
+	if err != nil {
+		return
+	}
+
+	// make sure the smarthost is reachable and accepts our credentials
+	ctx, cancel := context.WithTimeout(context.Background(), emb.conf.Timeout)
+	defer cancel()
+	c, err := emb.dial(ctx, tlsConfig)
+	if err != nil {
+		return
+	}
+	if err = c.Quit(); err != nil {
+		c.Close()
+		return
+	}
+	emb.tlsConfig = tlsConfig
+	return nil
+}
+
+func (emb *EMailBackend) dial(ctx context.Context, tlsConfig *tls.Config) (c *smtp.Client, err error) {
+	dialer := &net.Dialer{Timeout: emb.conf.Timeout}
+	var conn net.Conn
+	if emb.conf.TLSMode == EMailTLSImplicit {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", emb.addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", emb.addr)
+	}
+	if err != nil {
+		return
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(emb.conf.Timeout)
+	}
+	conn.SetDeadline(deadline)
+
+	if c, err = smtp.NewClient(conn, emb.host); err != nil {
+		conn.Close()
+		return
+	}
+	defer func() {
+		if err != nil {
+			c.Close()
+			c = nil
+		}
+	}()
+
+	hello := emb.conf.Hello
+	if hello == "" {
+		if hello, err = os.Hostname(); err != nil {
+			return
+		}
+	}
+	if err = c.Hello(hello); err != nil {
+		return
+	}
+
+	switch emb.conf.TLSMode {
+	case EMailTLSAuto, EMailTLSStartTLS:
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err = c.StartTLS(tlsConfig); err != nil {
+				return
+			}
+		} else if emb.conf.TLSMode == EMailTLSStartTLS {
+			err = errors.New("smarthost does not support STARTTLS")
+			return
+		}
+	}
+
+	if emb.auth != nil {
+		err = c.Auth(emb.auth)
+	}
+	return
 }
 
 func (emb *EMailBackend) ready() bool {
-	// TODO: implement this
-	return false
+	// the TLS config only gets set once the smarthost has accepted our connection
+	return emb.tlsConfig != nil
 }
 
 func (emb *EMailBackend) Ready() bool {
@@ -71,20 +231,168 @@ func (emb *EMailBackend) Ready() bool {
 	return emb.ready()
 }
 
-func (emb *EMailBackend) Notify(ctx context.Context, target NotifierTarget, alert *store.Alert) (bool, error) {
+func (emb *EMailBackend) message(to string, notification *Notification) ([]byte, error) {
+	ctx := notification.templateContext()
+	subject, err := emb.subject.Execute(ctx)
+	if err != nil {
+		return nil, err
+	}
+	body, err := emb.body.Execute(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	domain := emb.from.Address[strings.LastIndex(emb.from.Address, "@")+1:]
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "From: %s\r\n", emb.from.String())
+	fmt.Fprintf(buf, "To: %s\r\n", to)
+	fmt.Fprintf(buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(buf, "Message-ID: <%s@%s>\r\n", ulid.Make().String(), domain)
+	fmt.Fprintf(buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(buf, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(buf, "Content-Transfer-Encoding: quoted-printable\r\n")
+	fmt.Fprintf(buf, "\r\n")
+
+	qp := quotedprintable.NewWriter(buf)
+	if _, err = qp.Write([]byte(strings.ReplaceAll(body, "\n", "\r\n"))); err != nil {
+		return nil, err
+	}
+	if err = qp.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// smarthostError wraps errors of the connection to the smarthost. Only these errors cause the
+// backend to be re-initialized, errors reported by the smarthost or caused by the target or
+// the templates don't.
+type smarthostError struct {
+	err error
+}
+
+func (e *smarthostError) Error() string {
+	return e.err.Error()
+}
+
+func (e *smarthostError) Unwrap() error {
+	return e.err
+}
+
+func (emb *EMailBackend) Notify(ctx context.Context, target NotifierTarget, notification *Notification) (bool, error) {
+	sent, err := emb.notify(ctx, target, notification)
+	var connErr *smarthostError
+	if errors.As(err, &connErr) {
+		emb.fail(err)
+	}
+	return sent, err
+}
+
+func (emb *EMailBackend) notify(ctx context.Context, target NotifierTarget, notification *Notification) (bool, error) {
 	emb.mutex.RLock()
 	defer emb.mutex.RUnlock()
 
-	if target.EMail == nil || !emb.ready() {
+	if target.EMail == nil {
 		return false, nil
 	}
+	if !emb.ready() {
+		return false, errBackendNotReady
+	}
+
+	to, err := mail.ParseAddress(string(*target.EMail))
+	if err != nil {
+		return false, fmt.Errorf("invalid e-mail address for target '%s': %v", target.Name, err)
+	}
+	msg, err := emb.message(to.String(), notification)
+	if err != nil {
+		return false, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, emb.conf.Timeout)
+	defer cancel()
+	if err = emb.send(ctx, to.Address, msg); err != nil {
+		var protoErr *textproto.Error
+		if !errors.As(err, &protoErr) {
+			err = &smarthostError{err: err}
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (emb *EMailBackend) send(ctx context.Context, to string, msg []byte) error {
+	c, err := emb.dial(ctx, emb.tlsConfig)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if err = c.Mail(emb.from.Address); err != nil {
+		return err
+	}
+	if err = c.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(msg); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	if err = c.Quit(); err != nil {
+		emb.dbgLog.Printf("EMail(%s): failed to properly close connection to smarthost: %v", emb.name, err)
+	}
+	return nil
+}
 
-	return false, fmt.Errorf("not yet implemented!")
+func (emb *EMailBackend) fail(err error) {
+	emb.mutex.Lock()
+	defer emb.mutex.Unlock()
+
+	if emb.ready() {
+		emb.infoLog.Printf("EMail(%s): smarthost failed: %v", emb.name, err)
+		emb.tlsConfig = nil
+	}
 }
 
 func (emb *EMailBackend) Close() error {
 	emb.mutex.Lock()
 	defer emb.mutex.Unlock()
-	// TODO: close client?
+
+	emb.tlsConfig = nil
 	return nil
 }
+
+// loginAuth implements the non-standard but widely used LOGIN authentication mechanism.
+type loginAuth struct {
+	username string
+	password string
+	host     string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && server.Name != "localhost" && server.Name != "127.0.0.1" && server.Name != "::1" {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch strings.ToLower(strings.TrimSpace(string(fromServer))) {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
+	}
+	return nil, fmt.Errorf("unexpected server challenge: %s", fromServer)
+}
@@ -0,0 +1,304 @@
+//
+// Copyright (c) 2023 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.alerts nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package notifier
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/whawty/alerts/store"
+)
+
+// testCertificate returns a self-signed certificate for 127.0.0.1 and the path to a file
+// containing the certificate which can be used as CA certificate by clients.
+func testCertificate(t *testing.T) (tls.Certificate, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err = os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("failed to write CA certificate: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, caFile
+}
+
+type testSMTPMessage struct {
+	from string
+	to   []string
+	data string
+}
+
+// testSMTPServer is a minimal smarthost which requires STARTTLS and AUTH PLAIN before it
+// accepts messages. Recipients containing "reject" are refused.
+type testSMTPServer struct {
+	listener net.Listener
+	tls      *tls.Config
+	username string
+	password string
+	mutex    sync.Mutex
+	messages []testSMTPMessage
+}
+
+func newTestSMTPServer(t *testing.T, cert tls.Certificate) *testSMTPServer {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	s := &testSMTPServer{listener: l, tls: &tls.Config{Certificates: []tls.Certificate{cert}}, username: "hugo", password: "secret"}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.handle(conn)
+		}
+	}()
+	return s
+}
+
+func (s *testSMTPServer) handle(conn net.Conn) {
+	defer func() { conn.Close() }()
+	tc := textproto.NewConn(conn)
+	tc.PrintfLine("220 test ESMTP")
+
+	encrypted, authenticated := false, false
+	var msg testSMTPMessage
+	for {
+		line, err := tc.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(cmd) {
+		case "EHLO":
+			if encrypted {
+				tc.PrintfLine("250-test\r\n250 AUTH PLAIN")
+			} else {
+				tc.PrintfLine("250-test\r\n250 STARTTLS")
+			}
+		case "STARTTLS":
+			tc.PrintfLine("220 go ahead")
+			conn = tls.Server(conn, s.tls)
+			tc = textproto.NewConn(conn)
+			encrypted = true
+		case "AUTH":
+			mechanism, response, _ := strings.Cut(arg, " ")
+			credentials, _ := base64.StdEncoding.DecodeString(response)
+			if !encrypted || mechanism != "PLAIN" || string(credentials) != "\x00"+s.username+"\x00"+s.password {
+				tc.PrintfLine("535 authentication failed")
+				continue
+			}
+			authenticated = true
+			tc.PrintfLine("235 authenticated")
+		case "MAIL":
+			if !authenticated {
+				tc.PrintfLine("530 authentication required")
+				continue
+			}
+			msg = testSMTPMessage{from: arg}
+			tc.PrintfLine("250 ok")
+		case "RCPT":
+			if strings.Contains(arg, "reject") {
+				tc.PrintfLine("550 no such user")
+				continue
+			}
+			msg.to = append(msg.to, arg)
+			tc.PrintfLine("250 ok")
+		case "DATA":
+			tc.PrintfLine("354 go ahead")
+			data, err := io.ReadAll(tc.DotReader())
+			if err != nil {
+				return
+			}
+			msg.data = string(data)
+			s.mutex.Lock()
+			s.messages = append(s.messages, msg)
+			s.mutex.Unlock()
+			tc.PrintfLine("250 queued")
+		case "QUIT":
+			tc.PrintfLine("221 bye")
+			return
+		default:
+			tc.PrintfLine("250 ok")
+		}
+	}
+}
+
+func (s *testSMTPServer) received() []testSMTPMessage {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]testSMTPMessage{}, s.messages...)
+}
+
+func newTestEMailBackend(server *testSMTPServer, caFile, password string) *EMailBackend {
+	conf := &NotifierBackendConfigEMail{
+		From:      "Alerts <alerts@example.com>",
+		Smarthost: server.listener.Addr().String(),
+		Hello:     "test.example.com",
+		Timeout:   5 * time.Second,
+		TLSMode:   EMailTLSStartTLS,
+		TLS:       &TLSClientConfig{CACertificates: caFile},
+		Auth:      &NotifierBackendConfigEMailAuth{Mechanism: "plain", Username: "hugo", Password: password},
+	}
+	return NewEMailBackend("test", conf, testLog, testLog)
+}
+
+func TestEMailBackendStartTLSAndAuth(t *testing.T) {
+	cert, caFile := testCertificate(t)
+	server := newTestSMTPServer(t, cert)
+
+	if err := newTestEMailBackend(server, caFile, "wrong").Init(); err == nil || !strings.Contains(err.Error(), "535") {
+		t.Fatalf("expected the smarthost to reject wrong credentials, got %v", err)
+	}
+
+	emb := newTestEMailBackend(server, caFile, "secret")
+	if err := emb.Init(); err != nil {
+		t.Fatalf("failed to initialize backend: %v", err)
+	}
+	defer emb.Close()
+
+	to := NotifierTargetEMail("Hugo <hugo@example.com>")
+	alert := &store.Alert{ID: "01HAAAAAAAAAAAAAAAAAABCDEF", Name: `disk "full"`, Severity: store.SeverityCritical, Description: "only <1% & falling"}
+	sent, err := emb.Notify(context.Background(), NotifierTarget{Name: "hugo", EMail: &to}, &Notification{Alerts: []*store.Alert{alert}})
+	if err != nil || !sent {
+		t.Fatalf("failed to send notification: %v", err)
+	}
+
+	messages := server.received()
+	if len(messages) != 1 {
+		t.Fatalf("expected one message, got %d", len(messages))
+	}
+	msg := messages[0]
+	if msg.from != "FROM:<alerts@example.com>" || len(msg.to) != 1 || msg.to[0] != "TO:<hugo@example.com>" {
+		t.Errorf("unexpected envelope: from=%s to=%v", msg.from, msg.to)
+	}
+	parsed, err := mail.ReadMessage(strings.NewReader(msg.data))
+	if err != nil {
+		t.Fatalf("failed to parse message: %v", err)
+	}
+	if to := parsed.Header.Get("To"); to != `"Hugo" <hugo@example.com>` {
+		t.Errorf("unexpected To header: %s", to)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	if err != nil || !strings.HasSuffix(subject, `[critical] disk "full"`) {
+		t.Errorf("unexpected subject: %s (%v)", subject, err)
+	}
+	body, err := io.ReadAll(quotedprintable.NewReader(parsed.Body))
+	if err != nil {
+		t.Fatalf("failed to decode body: %v", err)
+	}
+	for _, expected := range []string{`disk "full"`, alert.ID, "only <1% & falling"} {
+		if !strings.Contains(string(body), expected) {
+			t.Errorf("message body does not contain %q:\n%s", expected, body)
+		}
+	}
+}
+
+func TestEMailBackendErrors(t *testing.T) {
+	cert, caFile := testCertificate(t)
+	server := newTestSMTPServer(t, cert)
+	emb := newTestEMailBackend(server, caFile, "secret")
+	if err := emb.Init(); err != nil {
+		t.Fatalf("failed to initialize backend: %v", err)
+	}
+	defer emb.Close()
+	notification := &Notification{Alerts: []*store.Alert{{ID: "01HAAAAAAAAAAAAAAAAAABCDEF", Name: "test"}}}
+
+	if sent, err := emb.Notify(context.Background(), NotifierTarget{Name: "sms-only"}, notification); sent || err != nil {
+		t.Fatalf("targets without e-mail address must be skipped, got %t, %v", sent, err)
+	}
+
+	// errors caused by the target or reported by the smarthost must not affect other targets
+	invalid := NotifierTargetEMail("not an address")
+	if _, err := emb.Notify(context.Background(), NotifierTarget{Name: "invalid", EMail: &invalid}, notification); err == nil {
+		t.Fatalf("invalid addresses must be rejected")
+	}
+	rejected := NotifierTargetEMail("reject@example.com")
+	if _, err := emb.Notify(context.Background(), NotifierTarget{Name: "rejected", EMail: &rejected}, notification); err == nil {
+		t.Fatalf("recipients refused by the smarthost must fail")
+	}
+	if !emb.Ready() {
+		t.Fatalf("backend must stay ready after errors which are not caused by the smarthost connection")
+	}
+
+	// connection errors cause the backend to be re-initialized
+	server.listener.Close()
+	hugo := NotifierTargetEMail("hugo@example.com")
+	if _, err := emb.Notify(context.Background(), NotifierTarget{Name: "hugo", EMail: &hugo}, notification); err == nil {
+		t.Fatalf("sending via an unreachable smarthost must fail")
+	}
+	if emb.Ready() {
+		t.Fatalf("backend must not be ready once the smarthost is unreachable")
+	}
+	if _, err := emb.Notify(context.Background(), NotifierTarget{Name: "hugo", EMail: &hugo}, notification); err != errBackendNotReady {
+		t.Fatalf("expected errBackendNotReady, got %v", err)
+	}
+}
@@ -0,0 +1,204 @@
+//
+// Copyright (c) 2023 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.alerts nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type ExecBackend struct {
+	infoLog   *log.Logger
+	dbgLog    *log.Logger
+	name      string
+	conf      *NotifierBackendConfigExec
+	path      string
+	semaphore chan struct{}
+	mutex     *sync.RWMutex
+}
+
+func NewExecBackend(name string, conf *NotifierBackendConfigExec, infoLog, dbgLog *log.Logger) *ExecBackend {
+	if conf.Timeout <= 0 {
+		conf.Timeout = 30 * time.Second
+	}
+	if conf.Concurrency == 0 {
+		conf.Concurrency = 4
+	}
+	return &ExecBackend{name: name, conf: conf, infoLog: infoLog, dbgLog: dbgLog, semaphore: make(chan struct{}, conf.Concurrency), mutex: &sync.RWMutex{}}
+}
+
+func (eb *ExecBackend) Init() (err error) {
+	eb.mutex.Lock()
+	defer eb.mutex.Unlock()
+
+	if len(eb.conf.Command) == 0 {
+		return fmt.Errorf("command is required")
+	}
+	eb.path, err = exec.LookPath(eb.conf.Command[0])
+	return
+}
+
+func (eb *ExecBackend) ready() bool {
+	return eb.path != ""
+}
+
+func (eb *ExecBackend) Ready() bool {
+	eb.mutex.RLock()
+	defer eb.mutex.RUnlock()
+
+	return eb.ready()
+}
+
+func envName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		}
+		return '_'
+	}, name)
+}
+
+// environment returns the variables which describe the notification. The WHAWTY_ALERT_*
+// variables describe the first, and therefore most severe, alert of the notification.
+func (eb *ExecBackend) environment(target NotifierTarget, notification *Notification) []string {
+	env := os.Environ()
+	for name, value := range eb.conf.Environment {
+		env = append(env, name+"="+value)
+	}
+	env = append(env, "WHAWTY_TARGET="+target.Name, "WHAWTY_TARGET_EXEC="+string(*target.Exec))
+	ids := make([]string, 0, len(notification.Alerts))
+	for _, alert := range notification.Alerts {
+		ids = append(ids, alert.ID)
+	}
+	env = append(env,
+		"WHAWTY_ALERT_COUNT="+strconv.Itoa(len(notification.Alerts)),
+		"WHAWTY_ALERT_IDS="+strings.Join(ids, " "),
+		"WHAWTY_SUPPRESSED="+strconv.FormatUint(uint64(notification.Suppressed), 10))
+	if len(notification.Alerts) == 0 {
+		return env
+	}
+
+	alert := notification.Alerts[0]
+	env = append(env,
+		"WHAWTY_ALERT_ID="+alert.ID,
+		"WHAWTY_ALERT_SHORT_ID="+alert.ShortID(),
+		"WHAWTY_ALERT_NAME="+alert.Name,
+		"WHAWTY_ALERT_STATE="+alert.State.String(),
+		"WHAWTY_ALERT_SEVERITY="+alert.Severity.String(),
+		"WHAWTY_ALERT_SOURCE="+alert.Source,
+		"WHAWTY_ALERT_DESCRIPTION="+alert.Description,
+		"WHAWTY_ALERT_FINGERPRINT="+alert.Fingerprint,
+		"WHAWTY_ALERT_OCCURRENCES="+strconv.FormatUint(uint64(alert.Occurrences), 10),
+		"WHAWTY_ALERT_CREATED="+alert.CreatedAt.Format(time.RFC3339))
+	for name, value := range alert.Labels {
+		env = append(env, "WHAWTY_ALERT_LABEL_"+envName(name)+"="+value)
+	}
+	for name, value := range alert.Annotations {
+		env = append(env, "WHAWTY_ALERT_ANNOTATION_"+envName(name)+"="+value)
+	}
+	return env
+}
+
+// Notify runs the command and waits for it to finish. At most concurrency commands are run at
+// the same time.
+func (eb *ExecBackend) Notify(ctx context.Context, target NotifierTarget, notification *Notification) (bool, error) {
+	eb.mutex.RLock()
+	defer eb.mutex.RUnlock()
+
+	if target.Exec == nil {
+		return false, nil
+	}
+	if !eb.ready() {
+		return false, errBackendNotReady
+	}
+	stdin, err := json.Marshal(notification.payload(target))
+	if err != nil {
+		return false, err
+	}
+
+	select {
+	case eb.semaphore <- struct{}{}:
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+	defer func() { <-eb.semaphore }()
+
+	ctx, cancel := context.WithTimeout(ctx, eb.conf.Timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, eb.path, eb.conf.Command[1:]...)
+	cmd.Env = eb.environment(target, notification)
+	cmd.Stdin = bytes.NewReader(stdin)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	// don't wait forever for children of the command which still hold on to stderr
+	cmd.WaitDelay = time.Second
+
+	if err = cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return false, fmt.Errorf("command timed out after %s", eb.conf.Timeout)
+		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			msg := strings.TrimSpace(stderr.String())
+			if len(msg) > 256 {
+				msg = msg[:256]
+			}
+			if msg != "" {
+				return false, fmt.Errorf("command failed with exit code %d: %s", exitErr.ExitCode(), msg)
+			}
+			return false, fmt.Errorf("command failed with exit code %d", exitErr.ExitCode())
+		}
+		return false, err
+	}
+	eb.dbgLog.Printf("Exec(%s): command for target '%s' finished successfully", eb.name, target.Name)
+	return true, nil
+}
+
+func (eb *ExecBackend) Close() error {
+	eb.mutex.Lock()
+	defer eb.mutex.Unlock()
+
+	eb.path = ""
+	return nil
+}
@@ -0,0 +1,105 @@
+//
+// Copyright (c) 2023 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.alerts nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package notifier
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/whawty/alerts/store"
+)
+
+func newTestExecBackend(t *testing.T, conf *NotifierBackendConfigExec) *ExecBackend {
+	t.Helper()
+	eb := NewExecBackend("test", conf, testLog, testLog)
+	if err := eb.Init(); err != nil {
+		t.Fatalf("failed to initialize backend: %v", err)
+	}
+	t.Cleanup(func() { eb.Close() })
+	return eb
+}
+
+func TestExecBackend(t *testing.T) {
+	script := `[ "$WHAWTY_TARGET_EXEC" = "pager-7" ] && [ "$WHAWTY_ALERT_NAME" = "disk full" ] && [ "$WHAWTY_ALERT_LABEL_HOST_NAME" = "db1" ] && grep -q '"target":"ops"' || { echo "unexpected input" >&2; exit 3; }`
+	eb := newTestExecBackend(t, &NotifierBackendConfigExec{Command: []string{"sh", "-c", script}})
+	pager := NotifierTargetExec("pager-7")
+	notification := &Notification{Alerts: []*store.Alert{{ID: "01HAAAAAAAAAAAAAAAAAAAAAAA", Name: "disk full", Labels: map[string]string{"host-name": "db1"}}}}
+
+	if sent, err := eb.Notify(context.Background(), NotifierTarget{Name: "email-only"}, notification); sent || err != nil {
+		t.Fatalf("targets without exec argument must be skipped, got %t, %v", sent, err)
+	}
+	if sent, err := eb.Notify(context.Background(), NotifierTarget{Name: "ops", Exec: &pager}, notification); err != nil || !sent {
+		t.Fatalf("failed to run command: %v", err)
+	}
+	other := NotifierTargetExec("pager-8")
+	if _, err := eb.Notify(context.Background(), NotifierTarget{Name: "ops", Exec: &other}, notification); err == nil || !strings.Contains(err.Error(), "exit code 3: unexpected input") {
+		t.Fatalf("expected exit code and stderr of the failed command, got %v", err)
+	}
+
+	eb = newTestExecBackend(t, &NotifierBackendConfigExec{Command: []string{"sleep", "10"}, Timeout: 100 * time.Millisecond})
+	if _, err := eb.Notify(context.Background(), NotifierTarget{Name: "ops", Exec: &pager}, notification); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("expected command to time out, got %v", err)
+	}
+}
+
+func TestExecBackendConcurrency(t *testing.T) {
+	// every command fails if more than two commands are running at the same time
+	script := `mkdir "$LOCKS/$$" && n=$(ls "$LOCKS" | wc -l) && sleep 0.2 && rmdir "$LOCKS/$$" && [ "$n" -le 2 ]`
+	conf := &NotifierBackendConfigExec{Command: []string{"sh", "-c", script}, Environment: map[string]string{"LOCKS": t.TempDir()}, Concurrency: 2}
+	eb := newTestExecBackend(t, conf)
+	pager := NotifierTargetExec("pager")
+	notification := &Notification{Alerts: []*store.Alert{{ID: "01HAAAAAAAAAAAAAAAAAAAAAAA", Name: "test"}}}
+
+	start := time.Now()
+	errs := make(chan error, 6)
+	var wg sync.WaitGroup
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := eb.Notify(context.Background(), NotifierTarget{Name: "ops", Exec: &pager}, notification)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("command failed: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 600*time.Millisecond {
+		t.Errorf("six commands with a concurrency of two finished after %s", elapsed)
+	}
+}
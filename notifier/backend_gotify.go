@@ -0,0 +1,159 @@
+//
+// Copyright (c) 2023 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.alerts nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/whawty/alerts/store"
+)
+
+var defaultGotifyPriorities = map[store.AlertSeverity]int{
+	store.SeverityCritical:      8,
+	store.SeverityWarning:       5,
+	store.SeverityInformational: 2,
+}
+
+type gotifyMessage struct {
+	Title    string `json:"title,omitempty"`
+	Message  string `json:"message"`
+	Priority int    `json:"priority"`
+}
+
+type GotifyBackend struct {
+	infoLog    *log.Logger
+	dbgLog     *log.Logger
+	name       string
+	conf       *NotifierBackendConfigGotify
+	client     *http.Client
+	templates  *pushTemplates
+	priorities map[store.AlertSeverity]int
+	mutex      *sync.RWMutex
+}
+
+func NewGotifyBackend(name string, conf *NotifierBackendConfigGotify, infoLog, dbgLog *log.Logger) *GotifyBackend {
+	if conf.Timeout <= 0 {
+		conf.Timeout = 10 * time.Second
+	}
+	return &GotifyBackend{name: name, conf: conf, infoLog: infoLog, dbgLog: dbgLog, mutex: &sync.RWMutex{}}
+}
+
+func (gb *GotifyBackend) Init() (err error) {
+	gb.mutex.Lock()
+	defer gb.mutex.Unlock()
+
+	if gb.conf.Server == "" {
+		return fmt.Errorf("server is required")
+	}
+	if gb.priorities, err = severityPriorities(gb.conf.Priorities, defaultGotifyPriorities); err != nil {
+		return
+	}
+	for severity, priority := range gb.priorities {
+		if priority < 0 || priority > 10 {
+			return fmt.Errorf("priority for severity '%s' must be between 0 and 10", severity)
+		}
+	}
+	if gb.templates, err = newPushTemplates(gb.conf.TitleTemplate, gb.conf.Template); err != nil {
+		return
+	}
+	gb.client, err = newHTTPClient(gb.conf.TLS, gb.conf.Timeout)
+	return
+}
+
+func (gb *GotifyBackend) ready() bool {
+	return gb.client != nil
+}
+
+func (gb *GotifyBackend) Ready() bool {
+	gb.mutex.RLock()
+	defer gb.mutex.RUnlock()
+
+	return gb.ready()
+}
+
+// Notify sends the notification using the application token of the target. The priority of the
+// message is derived from the highest severity of all alerts.
+func (gb *GotifyBackend) Notify(ctx context.Context, target NotifierTarget, notification *Notification) (bool, error) {
+	gb.mutex.RLock()
+	defer gb.mutex.RUnlock()
+
+	if target.Gotify == nil {
+		return false, nil
+	}
+	if !gb.ready() {
+		return false, errBackendNotReady
+	}
+	title, message, err := gb.templates.render(notification)
+	if err != nil {
+		return false, err
+	}
+	data, err := json.Marshal(gotifyMessage{Title: title, Message: message, Priority: gb.priorities[notification.MaxSeverity()]})
+	if err != nil {
+		return false, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(gb.conf.Server, "/")+"/message", bytes.NewReader(data))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Gotify-Key", string(*target.Gotify))
+	resp, err := gb.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	defer io.Copy(io.Discard, resp.Body)
+	if err = checkHTTPResponse(resp); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (gb *GotifyBackend) Close() error {
+	gb.mutex.Lock()
+	defer gb.mutex.Unlock()
+
+	if gb.client != nil {
+		gb.client.CloseIdleConnections()
+	}
+	gb.client = nil
+	return nil
+}
@@ -0,0 +1,394 @@
+//
+// Copyright (c) 2023 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.alerts nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flosch/pongo2/v6"
+	"github.com/oklog/ulid/v2"
+	"github.com/whawty/alerts/store"
+)
+
+const (
+	defaultMatrixTemplate = `{% autoescape off %}{% for alert in alerts %}{{ alert.State.Emoji() }} {{ alert.Severity.Emoji() }} [{{ alert.Severity }}] {{ alert.Name }} ({{ alert.ShortID() }}){% if alert.Description %}: {{ alert.Description }}{% endif %}
+{% endfor %}{% if notification.Suppressed %}{{ notification.Suppressed }} more alerts suppressed by rate limit{% endif %}{% endautoescape %}`
+	defaultMatrixHTMLTemplate = `{% for alert in alerts %}{{ alert.State.Emoji() }} {{ alert.Severity.Emoji() }} <b>{{ alert.Name }}</b> [{{ alert.Severity }}] <code>{{ alert.ShortID() }}</code>{% if alert.Description %}<br/>{{ alert.Description }}{% endif %}<br/>
+{% endfor %}{% if notification.Suppressed %}<i>{{ notification.Suppressed }} more alerts suppressed by rate limit</i>{% endif %}`
+
+	matrixSyncFilter  = `{"room":{"timeline":{"types":["m.room.message","m.reaction"]}},"presence":{"types":[]},"account_data":{"types":[]}}`
+	matrixSyncTimeout = 30 * time.Second
+	// the number of sent notifications which are remembered to map reactions to alerts
+	matrixEventLogSize = 256
+)
+
+type matrixEvent struct {
+	Type    string `json:"type"`
+	Sender  string `json:"sender"`
+	EventID string `json:"event_id"`
+	Content struct {
+		Body      string `json:"body"`
+		RelatesTo *struct {
+			RelType string `json:"rel_type"`
+			EventID string `json:"event_id"`
+			Key     string `json:"key"`
+		} `json:"m.relates_to"`
+	} `json:"content"`
+}
+
+type matrixSyncResponse struct {
+	NextBatch string `json:"next_batch"`
+	Rooms     struct {
+		Join map[string]struct {
+			Timeline struct {
+				Events []matrixEvent `json:"events"`
+			} `json:"timeline"`
+		} `json:"join"`
+	} `json:"rooms"`
+}
+
+type MatrixBackend struct {
+	infoLog  *log.Logger
+	dbgLog   *log.Logger
+	name     string
+	conf     *NotifierBackendConfigMatrix
+	commands *commandHandler
+	client   *http.Client
+	userID   string
+	plain    *pongo2.Template
+	html     *pongo2.Template
+	cancel   context.CancelFunc
+	mutex    *sync.RWMutex
+
+	events      map[string][]string
+	eventOrder  []string
+	eventsMutex sync.Mutex
+}
+
+func NewMatrixBackend(name string, conf *NotifierBackendConfigMatrix, commands *commandHandler, infoLog, dbgLog *log.Logger) *MatrixBackend {
+	if conf.Timeout <= 0 {
+		conf.Timeout = 10 * time.Second
+	}
+	return &MatrixBackend{name: name, conf: conf, commands: commands, infoLog: infoLog, dbgLog: dbgLog, mutex: &sync.RWMutex{}, events: make(map[string][]string)}
+}
+
+func (mb *MatrixBackend) Init() (err error) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	if mb.conf.Homeserver == "" || mb.conf.AccessToken == "" {
+		return fmt.Errorf("homeserver and access token are required")
+	}
+	tmpl := mb.conf.Template
+	if tmpl == "" {
+		tmpl = defaultMatrixTemplate
+	}
+	if mb.plain, err = pongo2.FromString(tmpl); err != nil {
+		return fmt.Errorf("failed to parse template: %v", err)
+	}
+	tmpl = mb.conf.HTMLTemplate
+	if tmpl == "" {
+		tmpl = defaultMatrixHTMLTemplate
+	}
+	if mb.html, err = pongo2.FromString(tmpl); err != nil {
+		return fmt.Errorf("failed to parse HTML template: %v", err)
+	}
+
+	// requests use their own timeouts since the sync requests are long-polling
+	client, err := newHTTPClient(mb.conf.TLS, 0)
+	if err != nil {
+		return
+	}
+	var whoami struct {
+		UserID string `json:"user_id"`
+	}
+	if err = mb.request(context.Background(), client, http.MethodGet, "/account/whoami", nil, nil, &whoami, mb.conf.Timeout); err != nil {
+		return
+	}
+	mb.userID = whoami.UserID
+	mb.dbgLog.Printf("Matrix(%s): logged in as %s", mb.name, mb.userID)
+
+	if mb.conf.Commands {
+		var ctx context.Context
+		ctx, mb.cancel = context.WithCancel(context.Background())
+		go mb.sync(ctx, client)
+	}
+	mb.client = client
+	return nil
+}
+
+func (mb *MatrixBackend) request(ctx context.Context, client *http.Client, method, path string, query url.Values, in, out interface{}, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	u := strings.TrimSuffix(mb.conf.Homeserver, "/") + "/_matrix/client/v3" + path
+	if query != nil {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+mb.conf.AccessToken)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err = checkHTTPResponse(resp); err != nil {
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (mb *MatrixBackend) send(ctx context.Context, client *http.Client, room, msgtype, plain, html string) (string, error) {
+	content := map[string]string{"msgtype": msgtype, "body": plain}
+	if html != "" {
+		content["format"] = "org.matrix.custom.html"
+		content["formatted_body"] = html
+	}
+	var resp struct {
+		EventID string `json:"event_id"`
+	}
+	path := "/rooms/" + url.PathEscape(room) + "/send/m.room.message/" + ulid.Make().String()
+	if err := mb.request(ctx, client, http.MethodPut, path, nil, content, &resp, mb.conf.Timeout); err != nil {
+		return "", err
+	}
+	return resp.EventID, nil
+}
+
+// sync receives new events from the homeserver until ctx is canceled. Events which have
+// happened before the backend got initialized are ignored.
+func (mb *MatrixBackend) sync(ctx context.Context, client *http.Client) {
+	since := ""
+	for {
+		query := url.Values{"filter": {matrixSyncFilter}, "timeout": {"0"}}
+		if since != "" {
+			query.Set("since", since)
+			query.Set("timeout", fmt.Sprintf("%d", matrixSyncTimeout.Milliseconds()))
+		}
+		var resp matrixSyncResponse
+		err := mb.request(ctx, client, http.MethodGet, "/sync", query, nil, &resp, mb.conf.Timeout+matrixSyncTimeout)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			var statusErr *httpStatusError
+			if errors.As(err, &statusErr) && statusErr.code == http.StatusUnauthorized {
+				mb.fail(client, err)
+				return
+			}
+			mb.infoLog.Printf("Matrix(%s): failed to sync: %v", mb.name, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(mb.conf.Timeout):
+			}
+			continue
+		}
+
+		if since != "" {
+			for room, joined := range resp.Rooms.Join {
+				for _, event := range joined.Timeline.Events {
+					mb.handleEvent(ctx, client, room, event)
+				}
+			}
+		}
+		since = resp.NextBatch
+	}
+}
+
+// handleEvent handles messages starting with "!" as commands. Reacting to a notification with
+// 👍 acknowledges and with ✅ closes all alerts of the notification.
+func (mb *MatrixBackend) handleEvent(ctx context.Context, client *http.Client, room string, event matrixEvent) {
+	if event.Sender == mb.userID {
+		return
+	}
+	target, exists := mb.commands.targetByMatrixRoom(room)
+	if !exists {
+		mb.dbgLog.Printf("Matrix(%s): ignoring event from unknown room %s", mb.name, room)
+		return
+	}
+
+	var reply string
+	switch event.Type {
+	case "m.room.message":
+		if !strings.HasPrefix(event.Content.Body, "!") {
+			return
+		}
+		mb.infoLog.Printf("Matrix(%s): received command from %s in room %s: %s", mb.name, event.Sender, room, event.Content.Body)
+		reply = mb.commands.handle(target, event.Sender, store.SourceMatrix, strings.TrimPrefix(event.Content.Body, "!"))
+	case "m.reaction":
+		relation := event.Content.RelatesTo
+		if relation == nil || relation.RelType != "m.annotation" {
+			return
+		}
+		var state store.AlertState
+		switch strings.TrimSuffix(relation.Key, "\ufe0f") {
+		case "👍":
+			state = store.StateAcknowledged
+		case "✅":
+			state = store.StateClosed
+		default:
+			return
+		}
+		ids := mb.notifiedAlerts(relation.EventID)
+		if len(ids) == 0 {
+			return
+		}
+		mb.infoLog.Printf("Matrix(%s): received reaction from %s in room %s: %s", mb.name, event.Sender, room, relation.Key)
+		reply = mb.commands.setStates(ids, state, event.Sender, store.SourceMatrix)
+	default:
+		return
+	}
+
+	if _, err := mb.send(ctx, client, room, "m.notice", reply, ""); err != nil {
+		mb.infoLog.Printf("Matrix(%s): failed to send reply to room %s: %v", mb.name, room, err)
+	}
+}
+
+func (mb *MatrixBackend) remember(eventID string, notification *Notification) {
+	mb.eventsMutex.Lock()
+	defer mb.eventsMutex.Unlock()
+
+	ids := make([]string, 0, len(notification.Alerts))
+	for _, alert := range notification.Alerts {
+		ids = append(ids, alert.ID)
+	}
+	mb.events[eventID] = ids
+	mb.eventOrder = append(mb.eventOrder, eventID)
+	if len(mb.eventOrder) > matrixEventLogSize {
+		delete(mb.events, mb.eventOrder[0])
+		mb.eventOrder = mb.eventOrder[1:]
+	}
+}
+
+func (mb *MatrixBackend) notifiedAlerts(eventID string) []string {
+	mb.eventsMutex.Lock()
+	defer mb.eventsMutex.Unlock()
+
+	return mb.events[eventID]
+}
+
+func (mb *MatrixBackend) fail(client *http.Client, err error) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	if mb.client != client {
+		return
+	}
+	mb.infoLog.Printf("Matrix(%s): homeserver rejected our access token: %v", mb.name, err)
+	mb.close()
+}
+
+func (mb *MatrixBackend) ready() bool {
+	return mb.client != nil
+}
+
+func (mb *MatrixBackend) Ready() bool {
+	mb.mutex.RLock()
+	defer mb.mutex.RUnlock()
+
+	return mb.ready()
+}
+
+func (mb *MatrixBackend) Notify(ctx context.Context, target NotifierTarget, notification *Notification) (bool, error) {
+	mb.mutex.RLock()
+	defer mb.mutex.RUnlock()
+
+	if target.Matrix == nil {
+		return false, nil
+	}
+	if !mb.ready() {
+		return false, errBackendNotReady
+	}
+	tplCtx := notification.templateContext()
+	plain, err := mb.plain.Execute(tplCtx)
+	if err != nil {
+		return false, err
+	}
+	html, err := mb.html.Execute(tplCtx)
+	if err != nil {
+		return false, err
+	}
+
+	eventID, err := mb.send(ctx, mb.client, string(*target.Matrix), "m.text", strings.TrimSpace(plain), strings.TrimSpace(html))
+	if err != nil {
+		return false, err
+	}
+	mb.remember(eventID, notification)
+	return true, nil
+}
+
+func (mb *MatrixBackend) close() {
+	if mb.cancel != nil {
+		mb.cancel()
+		mb.cancel = nil
+	}
+	if mb.client != nil {
+		mb.client.CloseIdleConnections()
+	}
+	mb.client = nil
+}
+
+func (mb *MatrixBackend) Close() error {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	mb.close()
+	return nil
+}
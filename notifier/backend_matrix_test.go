@@ -0,0 +1,244 @@
+//
+// Copyright (c) 2023 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.alerts nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/whawty/alerts/store"
+)
+
+type testMatrixMessage struct {
+	room    string
+	content map[string]string
+}
+
+// testHomeserver implements the parts of the Matrix client-server API used by the backend.
+// Events passed to the events channel are delivered by the next sync request.
+type testHomeserver struct {
+	*httptest.Server
+	token  string
+	room   string
+	events chan map[string]interface{}
+
+	mutex    sync.Mutex
+	messages []testMatrixMessage
+}
+
+func newTestHomeserver(t *testing.T, token, room string) *testHomeserver {
+	hs := &testHomeserver{token: token, room: room, events: make(chan map[string]interface{}, 8)}
+	hs.Server = httptest.NewServer(http.HandlerFunc(hs.handle))
+	t.Cleanup(hs.Close)
+	return hs
+}
+
+func (hs *testHomeserver) handle(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer "+hs.token {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"errcode":"M_UNKNOWN_TOKEN","error":"invalid access token"}`))
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/_matrix/client/v3")
+	switch {
+	case r.Method == http.MethodGet && path == "/account/whoami":
+		json.NewEncoder(w).Encode(map[string]string{"user_id": "@alerts:example.com"})
+	case r.Method == http.MethodPut && strings.HasPrefix(path, "/rooms/"):
+		room, _, found := strings.Cut(strings.TrimPrefix(path, "/rooms/"), "/send/m.room.message/")
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+		content := make(map[string]string)
+		if err := json.NewDecoder(r.Body).Decode(&content); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		hs.mutex.Lock()
+		hs.messages = append(hs.messages, testMatrixMessage{room: room, content: content})
+		eventID := fmt.Sprintf("$event%d", len(hs.messages))
+		hs.mutex.Unlock()
+		json.NewEncoder(w).Encode(map[string]string{"event_id": eventID})
+	case r.Method == http.MethodGet && path == "/sync":
+		var events []map[string]interface{}
+		if r.URL.Query().Get("since") == "" {
+			// the initial sync contains old events which must be ignored
+			events = append(events, map[string]interface{}{"type": "m.room.message", "sender": "@hugo:example.com", "event_id": "$old", "content": map[string]string{"body": "!close"}})
+		} else {
+			select {
+			case event := <-hs.events:
+				events = append(events, event)
+			case <-time.After(50 * time.Millisecond):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		resp := map[string]interface{}{"next_batch": "next"}
+		resp["rooms"] = map[string]interface{}{"join": map[string]interface{}{hs.room: map[string]interface{}{"timeline": map[string]interface{}{"events": events}}}}
+		json.NewEncoder(w).Encode(resp)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (hs *testHomeserver) received() []testMatrixMessage {
+	hs.mutex.Lock()
+	defer hs.mutex.Unlock()
+	return append([]testMatrixMessage(nil), hs.messages...)
+}
+
+func newTestMatrixBackend(hs *testHomeserver, token string, commands *commandHandler) *MatrixBackend {
+	conf := &NotifierBackendConfigMatrix{Homeserver: hs.URL, AccessToken: token, Timeout: 5 * time.Second, Commands: commands != nil}
+	return NewMatrixBackend("test", conf, commands, testLog, testLog)
+}
+
+func TestMatrixBackendNotify(t *testing.T) {
+	room := NotifierTargetMatrix("!ops:example.com")
+	hs := newTestHomeserver(t, "secret", string(room))
+
+	if err := newTestMatrixBackend(hs, "wrong", nil).Init(); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("expected the homeserver to reject a wrong access token, got %v", err)
+	}
+
+	mb := newTestMatrixBackend(hs, "secret", nil)
+	if err := mb.Init(); err != nil {
+		t.Fatalf("failed to initialize backend: %v", err)
+	}
+	defer mb.Close()
+	if mb.userID != "@alerts:example.com" {
+		t.Errorf("unexpected user ID: %s", mb.userID)
+	}
+
+	alert := &store.Alert{ID: "01HAAAAAAAAAAAAAAAAAABCDEF", Name: "disk full", Severity: store.SeverityCritical, Description: "only 1% left"}
+	notification := &Notification{Alerts: []*store.Alert{alert}}
+	if sent, err := mb.Notify(context.Background(), NotifierTarget{Name: "email-only"}, notification); sent || err != nil {
+		t.Fatalf("targets without Matrix room must be skipped, got %t, %v", sent, err)
+	}
+	sent, err := mb.Notify(context.Background(), NotifierTarget{Name: "ops", Matrix: &room}, notification)
+	if err != nil || !sent {
+		t.Fatalf("failed to send notification: %v", err)
+	}
+
+	messages := hs.received()
+	if len(messages) != 1 {
+		t.Fatalf("expected one message, got %d", len(messages))
+	}
+	msg := messages[0]
+	if msg.room != string(room) {
+		t.Errorf("message has been sent to wrong room: %s", msg.room)
+	}
+	if msg.content["msgtype"] != "m.text" || msg.content["format"] != "org.matrix.custom.html" {
+		t.Errorf("unexpected message type or format: %v", msg.content)
+	}
+	for _, expected := range []string{"[critical] disk full (" + alert.ShortID() + ")", "only 1% left"} {
+		if !strings.Contains(msg.content["body"], expected) {
+			t.Errorf("message body does not contain %q: %s", expected, msg.content["body"])
+		}
+	}
+	if !strings.Contains(msg.content["formatted_body"], "<b>disk full</b>") {
+		t.Errorf("unexpected formatted body: %s", msg.content["formatted_body"])
+	}
+
+	mb.Close()
+	if _, err := mb.Notify(context.Background(), NotifierTarget{Name: "ops", Matrix: &room}, notification); err != errBackendNotReady {
+		t.Fatalf("expected closed backend to be not ready, got %v", err)
+	}
+}
+
+func TestMatrixBackendCommands(t *testing.T) {
+	room := NotifierTargetMatrix("!ops:example.com")
+	hs := newTestHomeserver(t, "secret", string(room))
+	st := newTestStore(t)
+	targets := []NotifierTarget{{Name: "ops", Matrix: &room}}
+
+	mb := newTestMatrixBackend(hs, "secret", newCommandHandler(st, targets, testLog))
+	if err := mb.Init(); err != nil {
+		t.Fatalf("failed to initialize backend: %v", err)
+	}
+	defer mb.Close()
+
+	disk, _, err := st.CreateAlert(&store.Alert{Name: "disk full"})
+	if err != nil {
+		t.Fatalf("failed to create alert: %v", err)
+	}
+	load, _, err := st.CreateAlert(&store.Alert{Name: "load high"})
+	if err != nil {
+		t.Fatalf("failed to create alert: %v", err)
+	}
+	if _, err := mb.Notify(context.Background(), targets[0], &Notification{Alerts: []*store.Alert{load}}); err != nil {
+		t.Fatalf("failed to send notification: %v", err)
+	}
+	stateOf := func(id string) store.AlertState {
+		alert, err := st.GetAlert(id)
+		if err != nil {
+			t.Fatalf("failed to get alert: %v", err)
+		}
+		return alert.State
+	}
+
+	// our own messages and messages which are no commands are ignored
+	hs.events <- map[string]interface{}{"type": "m.room.message", "sender": "@alerts:example.com", "event_id": "$own", "content": map[string]string{"body": "!close " + disk.ShortID()}}
+	hs.events <- map[string]interface{}{"type": "m.room.message", "sender": "@hugo:example.com", "event_id": "$chat", "content": map[string]string{"body": "looking into it"}}
+	hs.events <- map[string]interface{}{"type": "m.room.message", "sender": "@hugo:example.com", "event_id": "$ack", "content": map[string]string{"body": "!ack " + disk.ShortID()}}
+	waitFor(t, "alert to be acknowledged", func() bool { return stateOf(disk.ID) == store.StateAcknowledged })
+
+	hs.events <- map[string]interface{}{"type": "m.reaction", "sender": "@hugo:example.com", "event_id": "$reaction", "content": map[string]interface{}{
+		"m.relates_to": map[string]string{"rel_type": "m.annotation", "event_id": "$event1", "key": "✅"},
+	}}
+	waitFor(t, "alert to be closed", func() bool { return stateOf(load.ID) == store.StateClosed })
+	if state := stateOf(disk.ID); state != store.StateAcknowledged {
+		t.Errorf("reaction changed the state of an alert which was not part of the notification: %s", state)
+	}
+
+	var replies []string
+	waitFor(t, "replies", func() bool {
+		replies = replies[:0]
+		for _, msg := range hs.received() {
+			if msg.content["msgtype"] == "m.notice" {
+				replies = append(replies, msg.content["body"])
+			}
+		}
+		return len(replies) == 2
+	})
+	if !strings.Contains(replies[0], disk.ShortID()) || !strings.Contains(replies[0], "acknowledged") {
+		t.Errorf("unexpected reply to command: %s", replies[0])
+	}
+	if !strings.Contains(replies[1], load.ShortID()) || !strings.Contains(replies[1], "closed") {
+		t.Errorf("unexpected reply to reaction: %s", replies[1])
+	}
+}
@@ -0,0 +1,181 @@
+//
+// Copyright (c) 2023 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.alerts nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/whawty/alerts/store"
+)
+
+const (
+	defaultNtfyServer = "https://ntfy.sh"
+)
+
+var defaultNtfyPriorities = map[store.AlertSeverity]int{
+	store.SeverityCritical:      5,
+	store.SeverityWarning:       4,
+	store.SeverityInformational: 3,
+}
+
+type ntfyMessage struct {
+	Topic    string   `json:"topic"`
+	Title    string   `json:"title,omitempty"`
+	Message  string   `json:"message"`
+	Priority int      `json:"priority"`
+	Tags     []string `json:"tags,omitempty"`
+}
+
+type NtfyBackend struct {
+	infoLog    *log.Logger
+	dbgLog     *log.Logger
+	name       string
+	conf       *NotifierBackendConfigNtfy
+	client     *http.Client
+	templates  *pushTemplates
+	priorities map[store.AlertSeverity]int
+	mutex      *sync.RWMutex
+}
+
+func NewNtfyBackend(name string, conf *NotifierBackendConfigNtfy, infoLog, dbgLog *log.Logger) *NtfyBackend {
+	if conf.Server == "" {
+		conf.Server = defaultNtfyServer
+	}
+	if conf.Timeout <= 0 {
+		conf.Timeout = 10 * time.Second
+	}
+	return &NtfyBackend{name: name, conf: conf, infoLog: infoLog, dbgLog: dbgLog, mutex: &sync.RWMutex{}}
+}
+
+func (nb *NtfyBackend) Init() (err error) {
+	nb.mutex.Lock()
+	defer nb.mutex.Unlock()
+
+	if nb.priorities, err = severityPriorities(nb.conf.Priorities, defaultNtfyPriorities); err != nil {
+		return
+	}
+	for severity, priority := range nb.priorities {
+		if priority < 1 || priority > 5 {
+			return fmt.Errorf("priority for severity '%s' must be between 1 and 5", severity)
+		}
+	}
+	if nb.templates, err = newPushTemplates(nb.conf.TitleTemplate, nb.conf.Template); err != nil {
+		return
+	}
+	nb.client, err = newHTTPClient(nb.conf.TLS, nb.conf.Timeout)
+	return
+}
+
+func (nb *NtfyBackend) ready() bool {
+	return nb.client != nil
+}
+
+func (nb *NtfyBackend) Ready() bool {
+	nb.mutex.RLock()
+	defer nb.mutex.RUnlock()
+
+	return nb.ready()
+}
+
+// Notify publishes the notification to the topic of the target. The priority of the message
+// is derived from the highest severity of all alerts and the states of the alerts are added
+// as tags.
+func (nb *NtfyBackend) Notify(ctx context.Context, target NotifierTarget, notification *Notification) (bool, error) {
+	nb.mutex.RLock()
+	defer nb.mutex.RUnlock()
+
+	if target.Ntfy == nil {
+		return false, nil
+	}
+	if !nb.ready() {
+		return false, errBackendNotReady
+	}
+	title, message, err := nb.templates.render(notification)
+	if err != nil {
+		return false, err
+	}
+	msg := ntfyMessage{Topic: string(*target.Ntfy), Title: title, Message: message, Priority: nb.priorities[notification.MaxSeverity()]}
+	for _, alert := range notification.Alerts {
+		tag := alert.State.Emoji().String()
+		found := false
+		for _, t := range msg.Tags {
+			found = found || t == tag
+		}
+		if !found {
+			msg.Tags = append(msg.Tags, tag)
+		}
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return false, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(nb.conf.Server, "/")+"/", bytes.NewReader(data))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if nb.conf.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+nb.conf.Token)
+	} else if nb.conf.Username != "" {
+		req.SetBasicAuth(nb.conf.Username, nb.conf.Password)
+	}
+	resp, err := nb.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	defer io.Copy(io.Discard, resp.Body)
+	if err = checkHTTPResponse(resp); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (nb *NtfyBackend) Close() error {
+	nb.mutex.Lock()
+	defer nb.mutex.Unlock()
+
+	if nb.client != nil {
+		nb.client.CloseIdleConnections()
+	}
+	nb.client = nil
+	return nil
+}
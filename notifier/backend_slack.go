@@ -0,0 +1,250 @@
+//
+// Copyright (c) 2023 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.alerts nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/whawty/alerts/store"
+)
+
+var slackSeverityColors = map[store.AlertSeverity]string{
+	store.SeverityCritical:      "#d32f2f",
+	store.SeverityWarning:       "#fbc02d",
+	store.SeverityInformational: "#1976d2",
+}
+
+var slackStateColors = map[store.AlertState]string{
+	store.StateAcknowledged: "#757575",
+	store.StateClosed:       "#388e3c",
+}
+
+type slackField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+type slackAttachment struct {
+	Fallback  string       `json:"fallback"`
+	Color     string       `json:"color"`
+	Title     string       `json:"title"`
+	TitleLink string       `json:"title_link,omitempty"`
+	Text      string       `json:"text,omitempty"`
+	Fields    []slackField `json:"fields,omitempty"`
+	Footer    string       `json:"footer,omitempty"`
+	Timestamp int64        `json:"ts,omitempty"`
+}
+
+type slackMessage struct {
+	Username    string            `json:"username,omitempty"`
+	IconEmoji   string            `json:"icon_emoji,omitempty"`
+	IconURL     string            `json:"icon_url,omitempty"`
+	Text        string            `json:"text,omitempty"`
+	Attachments []slackAttachment `json:"attachments,omitempty"`
+}
+
+type SlackBackend struct {
+	infoLog *log.Logger
+	dbgLog  *log.Logger
+	name    string
+	conf    *NotifierBackendConfigSlack
+	client  *http.Client
+	mutex   *sync.RWMutex
+}
+
+func NewSlackBackend(name string, conf *NotifierBackendConfigSlack, infoLog, dbgLog *log.Logger) *SlackBackend {
+	if conf.Timeout <= 0 {
+		conf.Timeout = 10 * time.Second
+	}
+	return &SlackBackend{name: name, conf: conf, infoLog: infoLog, dbgLog: dbgLog, mutex: &sync.RWMutex{}}
+}
+
+func (sb *SlackBackend) Init() (err error) {
+	sb.mutex.Lock()
+	defer sb.mutex.Unlock()
+
+	if sb.conf.UIURL != "" {
+		if u, err := url.Parse(sb.conf.UIURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("invalid UI URL: '%s'", sb.conf.UIURL)
+		}
+	}
+	sb.client, err = newHTTPClient(sb.conf.TLS, sb.conf.Timeout)
+	return
+}
+
+func (sb *SlackBackend) ready() bool {
+	return sb.client != nil
+}
+
+func (sb *SlackBackend) Ready() bool {
+	sb.mutex.RLock()
+	defer sb.mutex.RUnlock()
+
+	return sb.ready()
+}
+
+// slackEscaper escapes the characters which Slack uses for links and mentions, otherwise
+// alerts could mention e.g. the whole channel using <!channel>.
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+func (sb *SlackBackend) attachment(alert *store.Alert) slackAttachment {
+	a := slackAttachment{
+		Fallback:  slackEscaper.Replace(fmt.Sprintf("[%s] %s (%s)", alert.Severity, alert.Name, alert.ShortID())),
+		Color:     slackSeverityColors[alert.Severity],
+		Title:     slackEscaper.Replace(fmt.Sprintf("%s %s", alert.Severity.Emoji(), alert.Name)),
+		TitleLink: sb.conf.UIURL,
+		Text:      slackEscaper.Replace(alert.Description),
+		Footer:    "whawty.alerts | " + alert.ShortID(),
+		Timestamp: alert.CreatedAt.Unix(),
+	}
+	a.Fields = append(a.Fields,
+		slackField{Title: "State", Value: fmt.Sprintf("%s %s", alert.State.Emoji(), alert.State), Short: true},
+		slackField{Title: "Severity", Value: alert.Severity.String(), Short: true})
+	if len(alert.Labels) > 0 {
+		names := make([]string, 0, len(alert.Labels))
+		for name := range alert.Labels {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		labels := make([]string, 0, len(names))
+		for _, name := range names {
+			labels = append(labels, slackEscaper.Replace(fmt.Sprintf("`%s=%s`", name, alert.Labels[name])))
+		}
+		a.Fields = append(a.Fields, slackField{Title: "Labels", Value: strings.Join(labels, " ")})
+	}
+	return a
+}
+
+func (sb *SlackBackend) post(ctx context.Context, target NotifierTarget, msg *slackMessage) error {
+	u, err := url.Parse(string(*target.Slack))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("invalid webhook URL for target '%s'", target.Name)
+	}
+	msg.Username, msg.IconEmoji, msg.IconURL = sb.conf.Username, sb.conf.IconEmoji, sb.conf.IconURL
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := sb.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	defer io.Copy(io.Discard, resp.Body)
+	return checkHTTPResponse(resp)
+}
+
+// Notify posts one attachment per alert which is colored according to the severity of the alert.
+func (sb *SlackBackend) Notify(ctx context.Context, target NotifierTarget, notification *Notification) (bool, error) {
+	sb.mutex.RLock()
+	defer sb.mutex.RUnlock()
+
+	if target.Slack == nil {
+		return false, nil
+	}
+	if !sb.ready() {
+		return false, errBackendNotReady
+	}
+	msg := &slackMessage{}
+	if len(notification.Alerts) > 1 {
+		msg.Text = fmt.Sprintf("%d alerts", len(notification.Alerts))
+	}
+	if notification.Suppressed > 0 {
+		if msg.Text != "" {
+			msg.Text += ", "
+		}
+		msg.Text += fmt.Sprintf("%d more alerts suppressed by rate limit", notification.Suppressed)
+	}
+	for _, alert := range notification.Alerts {
+		msg.Attachments = append(msg.Attachments, sb.attachment(alert))
+	}
+	if err := sb.post(ctx, target, msg); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// FollowUp posts a message telling the channel that an alert has been acknowledged or closed.
+// Incoming webhooks can't modify messages which have already been posted.
+func (sb *SlackBackend) FollowUp(ctx context.Context, target NotifierTarget, alert *store.Alert, change *store.AlertStateChange) (bool, error) {
+	sb.mutex.RLock()
+	defer sb.mutex.RUnlock()
+
+	if target.Slack == nil || !sb.ready() {
+		return false, nil
+	}
+	text := fmt.Sprintf("%s alert '%s' (%s) is now %s", alert.State.Emoji(), alert.ShortID(), alert.Name, alert.State)
+	if change != nil && change.New == alert.State && change.Actor != "" {
+		text += " by " + change.Actor
+	}
+	text = slackEscaper.Replace(text)
+	msg := &slackMessage{Attachments: []slackAttachment{{
+		Fallback:  text,
+		Color:     slackStateColors[alert.State],
+		Title:     text,
+		TitleLink: sb.conf.UIURL,
+		Footer:    "whawty.alerts | " + alert.ShortID(),
+		Timestamp: alert.UpdatedAt.Unix(),
+	}}}
+	if err := sb.post(ctx, target, msg); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (sb *SlackBackend) Close() error {
+	sb.mutex.Lock()
+	defer sb.mutex.Unlock()
+
+	if sb.client != nil {
+		sb.client.CloseIdleConnections()
+	}
+	sb.client = nil
+	return nil
+}
@@ -0,0 +1,104 @@
+//
+// Copyright (c) 2023 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.alerts nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/whawty/alerts/store"
+)
+
+func TestSlackBackend(t *testing.T) {
+	server := newTestWebhookServer(t, http.StatusOK)
+	hook := NotifierTargetSlack(server.URL + "/hooks/abc")
+	target := NotifierTarget{Name: "ops", Slack: &hook}
+
+	if err := NewSlackBackend("test", &NotifierBackendConfigSlack{UIURL: "alerts.example.com"}, testLog, testLog).Init(); err == nil {
+		t.Fatalf("UI URLs without scheme must be rejected")
+	}
+	sb := NewSlackBackend("test", &NotifierBackendConfigSlack{Username: "alerts", UIURL: "https://alerts.example.com/ui/"}, testLog, testLog)
+	if err := sb.Init(); err != nil {
+		t.Fatalf("failed to initialize backend: %v", err)
+	}
+	defer sb.Close()
+
+	alert := &store.Alert{
+		ID:          "01HAAAAAAAAAAAAAAAAAABCDEF",
+		Name:        "<!channel> disk full",
+		Severity:    store.SeverityCritical,
+		Description: "see <https://example.com|here> & fix it",
+		Labels:      map[string]string{"host": "<@U123>"},
+	}
+	if sent, err := sb.Notify(context.Background(), NotifierTarget{Name: "email-only"}, &Notification{Alerts: []*store.Alert{alert}}); sent || err != nil {
+		t.Fatalf("targets without Slack webhook must be skipped, got %t, %v", sent, err)
+	}
+	if sent, err := sb.Notify(context.Background(), target, &Notification{Alerts: []*store.Alert{alert}}); err != nil || !sent {
+		t.Fatalf("failed to send notification: %v", err)
+	}
+	change := &store.AlertStateChange{New: store.StateAcknowledged, Actor: "<!here>"}
+	alert.State = store.StateAcknowledged
+	if sent, err := sb.FollowUp(context.Background(), target, alert, change); err != nil || !sent {
+		t.Fatalf("failed to send follow-up: %v", err)
+	}
+
+	requests := server.received()
+	if len(requests) != 2 {
+		t.Fatalf("expected two messages, got %d", len(requests))
+	}
+	var msg slackMessage
+	if err := json.Unmarshal(requests[0].body, &msg); err != nil || len(msg.Attachments) != 1 {
+		t.Fatalf("invalid message %s: %v", requests[0].body, err)
+	}
+	a := msg.Attachments[0]
+	if msg.Username != "alerts" || a.TitleLink != "https://alerts.example.com/ui/" || a.Color != slackSeverityColors[store.SeverityCritical] {
+		t.Errorf("unexpected username, link or color: %s", requests[0].body)
+	}
+	if a.Title != store.SeverityCritical.Emoji().String()+" &lt;!channel&gt; disk full" {
+		t.Errorf("title is not escaped: %s", a.Title)
+	}
+	if a.Text != "see &lt;https://example.com|here&gt; &amp; fix it" {
+		t.Errorf("text is not escaped: %s", a.Text)
+	}
+	if labels := a.Fields[len(a.Fields)-1]; labels.Value != "`host=&lt;@U123&gt;`" {
+		t.Errorf("labels are not escaped: %s", labels.Value)
+	}
+
+	if err := json.Unmarshal(requests[1].body, &msg); err != nil || len(msg.Attachments) != 1 {
+		t.Fatalf("invalid message %s: %v", requests[1].body, err)
+	}
+	expected := store.StateAcknowledged.Emoji().String() + " alert 'abcdef' (&lt;!channel&gt; disk full) is now acknowledged by &lt;!here&gt;"
+	if a = msg.Attachments[0]; a.Title != expected || a.TitleLink != "https://alerts.example.com/ui/" {
+		t.Errorf("unexpected follow-up: %s", requests[1].body)
+	}
+}
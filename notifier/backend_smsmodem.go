@@ -47,24 +47,32 @@ import (
 
 const (
 	// TODO: improve alert formatting
-	defaultTemplate = "{{ alert.State.Emoji() }} {{ alert.State }} | {{ alert.Severity.Emoji() }} {{ alert.Severity }} | {{ alert.Name }}"
+	defaultTemplate        = "{{ alert.State.Emoji() }} {{ alert.State }} | {{ alert.Severity.Emoji() }} {{ alert.Severity }} | {{ alert.Name }} [{{ alert.ShortID() }}]{% if notification.Suppressed %} (+{{ notification.Suppressed }} suppressed){% endif %}"
+	defaultSummaryTemplate = `{% autoescape off %}{% if alerts %}{{ alerts|length }} alerts{% for c in notification.SeverityCounts() %} | {{ c.Severity.Emoji() }} {{ c.Count }} {{ c.Severity }}{% endfor %}{% for alert in notification.First(summarySize) %}
+{{ alert.Name }} [{{ alert.ShortID() }}]{% endfor %}{% if notification.Remaining(summarySize) %}
+... and {{ notification.Remaining(summarySize) }} more{% endif %}{% if notification.Suppressed %}
+{% endif %}{% endif %}{% if notification.Suppressed %}{{ notification.Suppressed }} more alerts suppressed by rate limit{% endif %}{% endautoescape %}`
 )
 
 type SMSModemBackend struct {
-	infoLog *log.Logger
-	dbgLog  *log.Logger
-	name    string
-	conf    *NotifierBackendConfigSMSModem
-	modem   io.ReadWriteCloser
-	sms     *gsm.GSM
-	mutex   *sync.RWMutex
+	infoLog  *log.Logger
+	dbgLog   *log.Logger
+	name     string
+	conf     *NotifierBackendConfigSMSModem
+	commands *commandHandler
+	modem    io.ReadWriteCloser
+	sms      *gsm.GSM
+	mutex    *sync.RWMutex
 }
 
-func NewSMSModemBackend(name string, conf *NotifierBackendConfigSMSModem, infoLog, dbgLog *log.Logger) *SMSModemBackend {
+func NewSMSModemBackend(name string, conf *NotifierBackendConfigSMSModem, commands *commandHandler, infoLog, dbgLog *log.Logger) *SMSModemBackend {
 	if conf.Timeout <= 0 {
 		conf.Timeout = 5 * time.Second
 	}
-	return &SMSModemBackend{name: name, conf: conf, infoLog: infoLog, dbgLog: dbgLog, mutex: &sync.RWMutex{}}
+	if conf.SummarySize <= 0 {
+		conf.SummarySize = 3
+	}
+	return &SMSModemBackend{name: name, conf: conf, commands: commands, infoLog: infoLog, dbgLog: dbgLog, mutex: &sync.RWMutex{}}
 }
 
 func (smb *SMSModemBackend) Init() (err error) {
@@ -102,6 +110,8 @@ func (smb *SMSModemBackend) Init() (err error) {
 	err = smb.sms.StartMessageRx(
 		func(msg gsm.Message) {
 			smb.infoLog.Printf("SMSModem(%s): got SMS from '%s': %s", smb.name, msg.Number, msg.Message)
+			// replying from within the handler would block the modem
+			go smb.handleMessage(msg)
 		},
 		func(err error) {
 			smb.infoLog.Printf("SMSModem(%s): got SMS rx error: %v", smb.name, err)
@@ -113,6 +123,61 @@ func (smb *SMSModemBackend) Init() (err error) {
 		smb.sms = nil
 		return
 	}
+	go smb.watch(smb.sms)
+	return nil
+}
+
+// watch waits for the AT command interface to be closed. This happens if the modem
+// disappears (e.g. USB reset) or if the backend gets closed.
+func (smb *SMSModemBackend) watch(sms *gsm.GSM) {
+	<-sms.Closed()
+	smb.fail(sms, at.ErrClosed)
+}
+
+// fail resets the backend if sms is still the active modem connection. The notifier will
+// then try to re-initialize the backend.
+func (smb *SMSModemBackend) fail(sms *gsm.GSM, err error) {
+	smb.mutex.Lock()
+	defer smb.mutex.Unlock()
+
+	if smb.sms != sms {
+		return
+	}
+	smb.infoLog.Printf("SMSModem(%s): modem failed: %v", smb.name, err)
+	smb.modem.Close()
+	smb.modem = nil
+	smb.sms = nil
+}
+
+func (smb *SMSModemBackend) handleMessage(msg gsm.Message) {
+	target, ok := smb.commands.targetBySMS(msg.Number)
+	if !ok {
+		smb.infoLog.Printf("SMSModem(%s): ignoring SMS from unknown sender '%s'", smb.name, msg.Number)
+		return
+	}
+	reply := smb.commands.handle(target, target.Name, store.SourceSMS, msg.Message)
+	if err := smb.send(msg.Number, reply); err != nil {
+		smb.infoLog.Printf("SMSModem(%s): failed to send reply to '%s': %v", smb.name, msg.Number, err)
+	}
+}
+
+func (smb *SMSModemBackend) send(number, message string) error {
+	smb.mutex.RLock()
+	sms := smb.sms
+	if !smb.ready() {
+		smb.mutex.RUnlock()
+		return fmt.Errorf("modem is not ready")
+	}
+	resp, err := sms.SendLongMessage(number, message)
+	smb.mutex.RUnlock()
+
+	if err != nil {
+		if err == at.ErrClosed || err == at.ErrDeadlineExceeded {
+			smb.fail(sms, err)
+		}
+		return err
+	}
+	smb.dbgLog.Printf("SMSModem(%s): send sms response: %v", smb.name, resp)
 	return nil
 }
 
@@ -126,32 +191,41 @@ func (smb *SMSModemBackend) Ready() bool {
 	return smb.ready()
 }
 
-func (smb *SMSModemBackend) Notify(ctx context.Context, target NotifierTarget, alert *store.Alert) (bool, error) {
-	smb.mutex.RLock()
-	defer smb.mutex.RUnlock()
-
-	if target.SMS == nil || !smb.ready() {
+// Notify sends notifications containing a single alert using the template. Groups of alerts
+// and reports about suppressed alerts are summarized using the summary template which only
+// lists the first summarySize alerts.
+func (smb *SMSModemBackend) Notify(ctx context.Context, target NotifierTarget, notification *Notification) (bool, error) {
+	if target.SMS == nil {
 		return false, nil
 	}
+	if !smb.Ready() {
+		return false, errBackendNotReady
+	}
 
 	tmplText := smb.conf.Template
 	if tmplText == "" {
 		tmplText = defaultTemplate
 	}
+	if len(notification.Alerts) != 1 {
+		tmplText = smb.conf.SummaryTemplate
+		if tmplText == "" {
+			tmplText = defaultSummaryTemplate
+		}
+	}
 	tpl, err := pongo2.FromString(tmplText)
 	if err != nil {
 		return false, err
 	}
-	message, err := tpl.Execute(pongo2.Context{"alert": alert})
+	tplCtx := notification.templateContext()
+	tplCtx["summarySize"] = smb.conf.SummarySize
+	message, err := tpl.Execute(tplCtx)
 	if err != nil {
 		return false, err
 	}
 
-	resp, err := smb.sms.SendLongMessage(string(*target.SMS), message)
-	if err != nil {
+	if err = smb.send(string(*target.SMS), message); err != nil {
 		return false, err
 	}
-	smb.dbgLog.Printf("SMSModem(%s): send sms response: %v", smb.name, resp)
 	return true, nil
 }
 
@@ -159,6 +233,9 @@ func (smb *SMSModemBackend) Close() error {
 	smb.mutex.Lock()
 	defer smb.mutex.Unlock()
 
+	if !smb.ready() {
+		return nil
+	}
 	smb.sms.StopMessageRx()
 	smb.modem.Close()
 	smb.modem = nil
@@ -0,0 +1,353 @@
+//
+// Copyright (c) 2023 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.alerts nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flosch/pongo2/v6"
+	"github.com/whawty/alerts/store"
+)
+
+const (
+	defaultTelegramAPIURL   = "https://api.telegram.org"
+	defaultTelegramTemplate = `{% for alert in alerts %}{{ alert.State.Emoji() }} {{ alert.Severity.Emoji() }} <b>{{ alert.Name }}</b> [{{ alert.Severity }}] <code>{{ alert.ShortID() }}</code>{% if alert.Description %}
+{{ alert.Description }}{% endif %}
+{% endfor %}{% if notification.Suppressed %}<i>{{ notification.Suppressed }} more alerts suppressed by rate limit</i>{% endif %}`
+
+	telegramPollTimeout = 30 * time.Second
+	// the maximum number of alerts of a notification which get their own buttons
+	telegramMaxButtonRows = 8
+)
+
+type telegramResponse struct {
+	OK          bool            `json:"ok"`
+	Result      json.RawMessage `json:"result"`
+	Description string          `json:"description"`
+}
+
+type telegramButton struct {
+	Text         string `json:"text"`
+	CallbackData string `json:"callback_data"`
+}
+
+type telegramUpdate struct {
+	UpdateID      int64 `json:"update_id"`
+	CallbackQuery *struct {
+		ID   string `json:"id"`
+		From struct {
+			ID       int64  `json:"id"`
+			Username string `json:"username"`
+		} `json:"from"`
+		Message *struct {
+			Chat struct {
+				ID int64 `json:"id"`
+			} `json:"chat"`
+		} `json:"message"`
+		Data string `json:"data"`
+	} `json:"callback_query"`
+}
+
+type TelegramBackend struct {
+	infoLog  *log.Logger
+	dbgLog   *log.Logger
+	name     string
+	conf     *NotifierBackendConfigTelegram
+	commands *commandHandler
+	client   *http.Client
+	body     *pongo2.Template
+	cancel   context.CancelFunc
+	mutex    *sync.RWMutex
+}
+
+func NewTelegramBackend(name string, conf *NotifierBackendConfigTelegram, commands *commandHandler, infoLog, dbgLog *log.Logger) *TelegramBackend {
+	if conf.APIURL == "" {
+		conf.APIURL = defaultTelegramAPIURL
+	}
+	if conf.Timeout <= 0 {
+		conf.Timeout = 10 * time.Second
+	}
+	return &TelegramBackend{name: name, conf: conf, commands: commands, infoLog: infoLog, dbgLog: dbgLog, mutex: &sync.RWMutex{}}
+}
+
+func (tb *TelegramBackend) Init() (err error) {
+	tb.mutex.Lock()
+	defer tb.mutex.Unlock()
+
+	if tb.conf.Token == "" {
+		return fmt.Errorf("bot token is required")
+	}
+	tmpl := tb.conf.Template
+	if tmpl == "" {
+		tmpl = defaultTelegramTemplate
+	}
+	if tb.body, err = pongo2.FromString(tmpl); err != nil {
+		return fmt.Errorf("failed to parse template: %v", err)
+	}
+
+	// requests use their own timeouts since polling for updates takes a while
+	client, err := newHTTPClient(tb.conf.TLS, 0)
+	if err != nil {
+		return
+	}
+	var me struct {
+		Username string `json:"username"`
+	}
+	if err = tb.call(context.Background(), client, "getMe", nil, &me, tb.conf.Timeout); err != nil {
+		return
+	}
+	tb.dbgLog.Printf("Telegram(%s): logged in as @%s", tb.name, me.Username)
+
+	var ctx context.Context
+	ctx, tb.cancel = context.WithCancel(context.Background())
+	go tb.poll(ctx, client)
+	tb.client = client
+	return nil
+}
+
+// call invokes a method of the Bot API. Errors never contain the URL since it includes the
+// bot token.
+func (tb *TelegramBackend) call(ctx context.Context, client *http.Client, method string, params, result interface{}, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	u := strings.TrimSuffix(tb.conf.APIURL, "/") + "/bot" + tb.conf.Token + "/" + method
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("%s: invalid API URL", method)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("%s: %w", method, err)
+	}
+	defer resp.Body.Close()
+
+	var r telegramResponse
+	if err = json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return &httpStatusError{code: resp.StatusCode}
+		}
+		return fmt.Errorf("%s: invalid response: %v", method, err)
+	}
+	if !r.OK {
+		return &httpStatusError{code: resp.StatusCode, body: r.Description}
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(r.Result, result)
+}
+
+// poll receives callback queries until ctx is canceled.
+func (tb *TelegramBackend) poll(ctx context.Context, client *http.Client) {
+	var offset int64
+	for {
+		params := map[string]interface{}{
+			"offset":          offset,
+			"timeout":         int(telegramPollTimeout.Seconds()),
+			"allowed_updates": []string{"callback_query"},
+		}
+		var updates []telegramUpdate
+		err := tb.call(ctx, client, "getUpdates", params, &updates, tb.conf.Timeout+telegramPollTimeout)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			var statusErr *httpStatusError
+			if errors.As(err, &statusErr) && statusErr.code == http.StatusUnauthorized {
+				tb.fail(client, err)
+				return
+			}
+			tb.infoLog.Printf("Telegram(%s): failed to get updates: %v", tb.name, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(tb.conf.Timeout):
+			}
+			continue
+		}
+
+		for _, update := range updates {
+			offset = update.UpdateID + 1
+			if update.CallbackQuery != nil {
+				tb.handleCallback(ctx, client, update)
+			}
+		}
+	}
+}
+
+// handleCallback changes the state of the alert whose button has been pressed. Only buttons
+// in chats of known targets are accepted.
+func (tb *TelegramBackend) handleCallback(ctx context.Context, client *http.Client, update telegramUpdate) {
+	query := update.CallbackQuery
+	reply := "unknown chat"
+	if query.Message != nil {
+		chat := strconv.FormatInt(query.Message.Chat.ID, 10)
+		if _, exists := tb.commands.targetByTelegramChat(chat); exists {
+			actor := "telegram:" + strconv.FormatInt(query.From.ID, 10)
+			if query.From.Username != "" {
+				actor = "@" + query.From.Username
+			}
+			tb.infoLog.Printf("Telegram(%s): received callback query from %s in chat %s: %s", tb.name, actor, chat, query.Data)
+			reply = tb.callback(query.Data, actor)
+			if err := tb.call(ctx, client, "sendMessage", map[string]interface{}{"chat_id": chat, "text": reply}, nil, tb.conf.Timeout); err != nil {
+				tb.infoLog.Printf("Telegram(%s): failed to send reply to chat %s: %v", tb.name, chat, err)
+			}
+		}
+	}
+	if err := tb.call(ctx, client, "answerCallbackQuery", map[string]interface{}{"callback_query_id": query.ID, "text": reply}, nil, tb.conf.Timeout); err != nil {
+		tb.infoLog.Printf("Telegram(%s): failed to answer callback query: %v", tb.name, err)
+	}
+}
+
+func (tb *TelegramBackend) callback(data, actor string) string {
+	command, id, found := strings.Cut(data, ":")
+	if !found {
+		return "invalid button"
+	}
+	var state store.AlertState
+	switch command {
+	case "ack":
+		state = store.StateAcknowledged
+	case "close":
+		state = store.StateClosed
+	default:
+		return "invalid button"
+	}
+	return tb.commands.setStates([]string{id}, state, actor, store.SourceTelegram)
+}
+
+// buttons returns one row of buttons for every alert of the notification.
+func (tb *TelegramBackend) buttons(notification *Notification) (rows [][]telegramButton) {
+	alerts := notification.First(telegramMaxButtonRows)
+	for _, alert := range alerts {
+		ack, closeAlert := "Acknowledge", "Close"
+		if len(alerts) > 1 {
+			ack = "Acknowledge " + alert.ShortID()
+			closeAlert = "Close " + alert.ShortID()
+		}
+		rows = append(rows, []telegramButton{
+			{Text: ack, CallbackData: "ack:" + alert.ID},
+			{Text: closeAlert, CallbackData: "close:" + alert.ID},
+		})
+	}
+	return
+}
+
+func (tb *TelegramBackend) fail(client *http.Client, err error) {
+	tb.mutex.Lock()
+	defer tb.mutex.Unlock()
+
+	if tb.client != client {
+		return
+	}
+	tb.infoLog.Printf("Telegram(%s): bot token has been rejected: %v", tb.name, err)
+	tb.close()
+}
+
+func (tb *TelegramBackend) ready() bool {
+	return tb.client != nil
+}
+
+func (tb *TelegramBackend) Ready() bool {
+	tb.mutex.RLock()
+	defer tb.mutex.RUnlock()
+
+	return tb.ready()
+}
+
+func (tb *TelegramBackend) Notify(ctx context.Context, target NotifierTarget, notification *Notification) (bool, error) {
+	tb.mutex.RLock()
+	defer tb.mutex.RUnlock()
+
+	if target.Telegram == nil {
+		return false, nil
+	}
+	if !tb.ready() {
+		return false, errBackendNotReady
+	}
+	text, err := tb.body.Execute(notification.templateContext())
+	if err != nil {
+		return false, err
+	}
+	params := map[string]interface{}{
+		"chat_id":    string(*target.Telegram),
+		"text":       strings.TrimSpace(text),
+		"parse_mode": "HTML",
+	}
+	if buttons := tb.buttons(notification); len(buttons) > 0 {
+		params["reply_markup"] = map[string]interface{}{"inline_keyboard": buttons}
+	}
+	if err = tb.call(ctx, tb.client, "sendMessage", params, nil, tb.conf.Timeout); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (tb *TelegramBackend) close() {
+	if tb.cancel != nil {
+		tb.cancel()
+		tb.cancel = nil
+	}
+	if tb.client != nil {
+		tb.client.CloseIdleConnections()
+	}
+	tb.client = nil
+}
+
+func (tb *TelegramBackend) Close() error {
+	tb.mutex.Lock()
+	defer tb.mutex.Unlock()
+
+	tb.close()
+	return nil
+}
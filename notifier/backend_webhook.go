@@ -0,0 +1,209 @@
+//
+// Copyright (c) 2023 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.alerts nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/flosch/pongo2/v6"
+)
+
+const (
+	defaultWebhookHMACHeader = "X-Whawty-Signature"
+)
+
+type WebhookBackend struct {
+	infoLog *log.Logger
+	dbgLog  *log.Logger
+	name    string
+	conf    *NotifierBackendConfigWebhook
+	client  *http.Client
+	body    *pongo2.Template
+	mutex   *sync.RWMutex
+}
+
+func NewWebhookBackend(name string, conf *NotifierBackendConfigWebhook, infoLog, dbgLog *log.Logger) *WebhookBackend {
+	if conf.Timeout <= 0 {
+		conf.Timeout = 10 * time.Second
+	}
+	if conf.RetryDelay <= 0 {
+		conf.RetryDelay = 1 * time.Second
+	}
+	return &WebhookBackend{name: name, conf: conf, infoLog: infoLog, dbgLog: dbgLog, mutex: &sync.RWMutex{}}
+}
+
+func (wb *WebhookBackend) Init() (err error) {
+	wb.mutex.Lock()
+	defer wb.mutex.Unlock()
+
+	wb.body = nil
+	if wb.conf.Template != "" {
+		if wb.body, err = pongo2.FromString(wb.conf.Template); err != nil {
+			return fmt.Errorf("failed to parse template: %v", err)
+		}
+	}
+	if wb.conf.HMAC != nil && wb.conf.HMAC.Secret == "" {
+		return fmt.Errorf("HMAC signing requires a secret")
+	}
+	wb.client, err = newHTTPClient(wb.conf.TLS, wb.conf.Timeout)
+	return
+}
+
+func (wb *WebhookBackend) ready() bool {
+	return wb.client != nil
+}
+
+func (wb *WebhookBackend) Ready() bool {
+	wb.mutex.RLock()
+	defer wb.mutex.RUnlock()
+
+	return wb.ready()
+}
+
+func (wb *WebhookBackend) render(target NotifierTarget, notification *Notification) (body []byte, contentType string, err error) {
+	if wb.body == nil {
+		body, err = json.Marshal(notification.payload(target))
+		return body, "application/json", err
+	}
+
+	ctx := notification.templateContext()
+	ctx["target"] = target
+	var rendered string
+	if rendered, err = wb.body.Execute(ctx); err != nil {
+		return
+	}
+	contentType = wb.conf.ContentType
+	if contentType == "" {
+		contentType = "text/plain; charset=utf-8"
+	}
+	return []byte(rendered), contentType, nil
+}
+
+func (wb *WebhookBackend) post(ctx context.Context, endpoint string, body []byte, contentType string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", "whawty-alerts")
+	for name, value := range wb.conf.Headers {
+		req.Header.Set(name, value)
+	}
+	if wb.conf.HMAC != nil {
+		header := wb.conf.HMAC.Header
+		if header == "" {
+			header = defaultWebhookHMACHeader
+		}
+		mac := hmac.New(sha256.New, []byte(wb.conf.HMAC.Secret))
+		mac.Write(body)
+		req.Header.Set(header, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := wb.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	defer io.Copy(io.Discard, resp.Body)
+	return checkHTTPResponse(resp)
+}
+
+// Notify POSTs the notification to the URL of the target. Requests which fail because of
+// network or server errors are retried as long as the timeout allows it while requests which
+// have been rejected by the server are not.
+func (wb *WebhookBackend) Notify(ctx context.Context, target NotifierTarget, notification *Notification) (bool, error) {
+	wb.mutex.RLock()
+	defer wb.mutex.RUnlock()
+
+	if target.Webhook == nil {
+		return false, nil
+	}
+	if !wb.ready() {
+		return false, errBackendNotReady
+	}
+	u, err := url.Parse(string(*target.Webhook))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return false, fmt.Errorf("invalid webhook URL for target '%s'", target.Name)
+	}
+	body, contentType, err := wb.render(target, notification)
+	if err != nil {
+		return false, err
+	}
+
+	// all attempts share the timeout, notifications are sent one after another and a failing
+	// webhook must not hold up the others
+	ctx, cancel := context.WithTimeout(ctx, wb.conf.Timeout)
+	defer cancel()
+	deadline, _ := ctx.Deadline()
+
+	delay := wb.conf.RetryDelay
+	for attempt := uint(0); ; attempt++ {
+		err = wb.post(ctx, u.String(), body, contentType)
+		if err == nil {
+			return true, nil
+		}
+		var statusErr *httpStatusError
+		if (errors.As(err, &statusErr) && !statusErr.Temporary()) || attempt >= wb.conf.Retries || time.Until(deadline) < delay {
+			return false, err
+		}
+		wb.dbgLog.Printf("Webhook(%s): request to '%s' failed, retrying in %s: %v", wb.name, u.Redacted(), delay, err)
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+func (wb *WebhookBackend) Close() error {
+	wb.mutex.Lock()
+	defer wb.mutex.Unlock()
+
+	if wb.client != nil {
+		wb.client.CloseIdleConnections()
+	}
+	wb.client = nil
+	return nil
+}
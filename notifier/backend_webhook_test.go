@@ -0,0 +1,200 @@
+//
+// Copyright (c) 2023 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.alerts nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package notifier
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/whawty/alerts/store"
+)
+
+type testWebhookRequest struct {
+	header http.Header
+	body   []byte
+}
+
+// testWebhookServer answers requests with the given status codes, the last one is repeated
+// for all further requests.
+type testWebhookServer struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	statuses []int
+	requests []testWebhookRequest
+}
+
+func newTestWebhookServer(t *testing.T, statuses ...int) *testWebhookServer {
+	s := &testWebhookServer{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		s.requests = append(s.requests, testWebhookRequest{header: r.Header, body: body})
+		status := s.statuses[0]
+		if len(s.statuses) > 1 {
+			s.statuses = s.statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *testWebhookServer) received() []testWebhookRequest {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]testWebhookRequest(nil), s.requests...)
+}
+
+func (s *testWebhookServer) target() NotifierTarget {
+	u := NotifierTargetWebhook(s.URL + "/hook")
+	return NotifierTarget{Name: "ops", Webhook: &u}
+}
+
+func newTestWebhookBackend(t *testing.T, conf *NotifierBackendConfigWebhook) *WebhookBackend {
+	t.Helper()
+	wb := NewWebhookBackend("test", conf, testLog, testLog)
+	if err := wb.Init(); err != nil {
+		t.Fatalf("failed to initialize backend: %v", err)
+	}
+	t.Cleanup(func() { wb.Close() })
+	return wb
+}
+
+func testWebhookNotification() *Notification {
+	return &Notification{Alerts: []*store.Alert{{ID: "01HAAAAAAAAAAAAAAAAAAAAAAA", Name: "disk full"}}}
+}
+
+func TestWebhookBackendSignature(t *testing.T) {
+	server := newTestWebhookServer(t, http.StatusOK)
+	if err := NewWebhookBackend("test", &NotifierBackendConfigWebhook{HMAC: &NotifierBackendConfigWebhookHMAC{}}, testLog, testLog).Init(); err == nil {
+		t.Fatalf("HMAC signing without secret must be rejected")
+	}
+
+	wb := newTestWebhookBackend(t, &NotifierBackendConfigWebhook{HMAC: &NotifierBackendConfigWebhookHMAC{Secret: "secret"}, Headers: map[string]string{"X-Env": "test"}})
+	if sent, err := wb.Notify(context.Background(), NotifierTarget{Name: "email-only"}, testWebhookNotification()); sent || err != nil {
+		t.Fatalf("targets without webhook URL must be skipped, got %t, %v", sent, err)
+	}
+	if sent, err := wb.Notify(context.Background(), server.target(), testWebhookNotification()); err != nil || !sent {
+		t.Fatalf("failed to send notification: %v", err)
+	}
+
+	requests := server.received()
+	if len(requests) != 1 {
+		t.Fatalf("expected one request, got %d", len(requests))
+	}
+	req := requests[0]
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(req.body)
+	if signature := req.header.Get("X-Whawty-Signature"); signature != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+		t.Errorf("invalid signature: %s", signature)
+	}
+	if req.header.Get("Content-Type") != "application/json" || req.header.Get("X-Env") != "test" {
+		t.Errorf("unexpected headers: %v", req.header)
+	}
+	var payload notificationPayload
+	if err := json.Unmarshal(req.body, &payload); err != nil {
+		t.Fatalf("failed to decode payload: %v", err)
+	}
+	if payload.Target != "ops" || len(payload.Alerts) != 1 || payload.Alerts[0].Name != "disk full" {
+		t.Errorf("unexpected payload: %s", req.body)
+	}
+
+	// the header is configurable and templates are signed as rendered
+	wb = newTestWebhookBackend(t, &NotifierBackendConfigWebhook{HMAC: &NotifierBackendConfigWebhookHMAC{Secret: "secret", Header: "X-Signature"}, Template: "{{ alert.Name }} for {{ target.Name }}"})
+	if _, err := wb.Notify(context.Background(), server.target(), testWebhookNotification()); err != nil {
+		t.Fatalf("failed to send notification: %v", err)
+	}
+	req = server.received()[1]
+	mac = hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte("disk full for ops"))
+	if string(req.body) != "disk full for ops" || req.header.Get("X-Signature") != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+		t.Errorf("unexpected body or signature: %s, %s", req.body, req.header.Get("X-Signature"))
+	}
+	if contentType := req.header.Get("Content-Type"); contentType != "text/plain; charset=utf-8" {
+		t.Errorf("unexpected content type: %s", contentType)
+	}
+}
+
+func TestWebhookBackendRetries(t *testing.T) {
+	testVectors := []struct {
+		name     string
+		statuses []int
+		retries  uint
+		sent     bool
+		requests int
+	}{
+		{"server errors are retried", []int{http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusOK}, 3, true, 3},
+		{"rate limits are retried", []int{http.StatusTooManyRequests, http.StatusOK}, 3, true, 2},
+		{"client errors are not retried", []int{http.StatusBadRequest, http.StatusOK}, 3, false, 1},
+		{"retries are limited", []int{http.StatusInternalServerError}, 2, false, 3},
+		{"no retries", []int{http.StatusInternalServerError, http.StatusOK}, 0, false, 1},
+	}
+
+	for _, vector := range testVectors {
+		server := newTestWebhookServer(t, vector.statuses...)
+		wb := newTestWebhookBackend(t, &NotifierBackendConfigWebhook{Retries: vector.retries, RetryDelay: time.Millisecond})
+		sent, err := wb.Notify(context.Background(), server.target(), testWebhookNotification())
+		if sent != vector.sent || (err == nil) != vector.sent {
+			t.Errorf("%s: unexpected result %t, %v", vector.name, sent, err)
+		}
+		if requests := len(server.received()); requests != vector.requests {
+			t.Errorf("%s: expected %d requests, got %d", vector.name, vector.requests, requests)
+		}
+	}
+}
+
+func TestWebhookBackendRetryTimeout(t *testing.T) {
+	server := newTestWebhookServer(t, http.StatusServiceUnavailable)
+	timeout := 300 * time.Millisecond
+	wb := newTestWebhookBackend(t, &NotifierBackendConfigWebhook{Timeout: timeout, Retries: 100, RetryDelay: 50 * time.Millisecond})
+
+	start := time.Now()
+	if sent, err := wb.Notify(context.Background(), server.target(), testWebhookNotification()); sent || err == nil {
+		t.Fatalf("notification must fail, got %t, %v", sent, err)
+	}
+	if elapsed := time.Since(start); elapsed > timeout {
+		t.Errorf("retries took %s which is longer than the timeout", elapsed)
+	}
+	// delays of 50, 100 and 200ms don't fit into the timeout anymore
+	if requests := len(server.received()); requests != 3 {
+		t.Errorf("expected 3 requests, got %d", requests)
+	}
+}
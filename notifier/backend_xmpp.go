@@ -0,0 +1,600 @@
+//
+// Copyright (c) 2023 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.alerts nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package notifier
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flosch/pongo2/v6"
+	"github.com/whawty/alerts/store"
+)
+
+const (
+	defaultXMPPTemplate = `{% autoescape off %}{% for alert in alerts %}{{ alert.State.Emoji() }} {{ alert.Severity.Emoji() }} [{{ alert.Severity }}] {{ alert.Name }} ({{ alert.ShortID() }}){% if alert.Description %}: {{ alert.Description }}{% endif %}
+{% endfor %}{% if notification.Suppressed %}{{ notification.Suppressed }} more alerts suppressed by rate limit
+{% endif %}reply with "ack <id>" or "close <id>"{% endautoescape %}`
+	defaultXMPPResource = "whawty.alerts"
+	defaultXMPPNick     = "whawty.alerts"
+
+	// whitespace is sent regularly to keep the connection alive and detect broken connections
+	xmppKeepaliveInterval = time.Minute
+
+	nsXMPPStreams  = "http://etherx.jabber.org/streams"
+	nsXMPPTLS      = "urn:ietf:params:xml:ns:xmpp-tls"
+	nsXMPPSASL     = "urn:ietf:params:xml:ns:xmpp-sasl"
+	nsXMPPBind     = "urn:ietf:params:xml:ns:xmpp-bind"
+	nsXMPPSession  = "urn:ietf:params:xml:ns:xmpp-session"
+	nsXMPPStanzas  = "urn:ietf:params:xml:ns:xmpp-stanzas"
+	nsXMPPMUC      = "http://jabber.org/protocol/muc"
+	nsXMPPPing     = "urn:xmpp:ping"
+	nsXMPPDelay    = "urn:xmpp:delay"
+	xmppSASLMethod = "PLAIN"
+)
+
+type xmppFeatures struct {
+	StartTLS   *struct{} `xml:"urn:ietf:params:xml:ns:xmpp-tls starttls"`
+	Mechanisms *struct {
+		Mechanism []string `xml:"mechanism"`
+	} `xml:"urn:ietf:params:xml:ns:xmpp-sasl mechanisms"`
+	Bind    *struct{} `xml:"urn:ietf:params:xml:ns:xmpp-bind bind"`
+	Session *struct {
+		Optional *struct{} `xml:"optional"`
+	} `xml:"urn:ietf:params:xml:ns:xmpp-session session"`
+}
+
+// xmppError holds the condition of stream errors, SASL failures and stanza errors.
+type xmppError struct {
+	Children []struct {
+		XMLName xml.Name
+		Text    string `xml:",chardata"`
+	} `xml:",any"`
+}
+
+func (e *xmppError) String() string {
+	condition, text := "", ""
+	for _, child := range e.Children {
+		if child.XMLName.Local == "text" {
+			text = strings.TrimSpace(child.Text)
+		} else if condition == "" {
+			condition = child.XMLName.Local
+		}
+	}
+	if text != "" {
+		return condition + ": " + text
+	}
+	return condition
+}
+
+type xmppIQ struct {
+	Type string `xml:"type,attr"`
+	ID   string `xml:"id,attr"`
+	From string `xml:"from,attr"`
+	Bind *struct {
+		JID string `xml:"jid"`
+	} `xml:"urn:ietf:params:xml:ns:xmpp-bind bind"`
+	Ping  *struct{}  `xml:"urn:xmpp:ping ping"`
+	Error *xmppError `xml:"error"`
+}
+
+type xmppMessage struct {
+	From  string    `xml:"from,attr"`
+	Type  string    `xml:"type,attr"`
+	Body  string    `xml:"body"`
+	Delay *struct{} `xml:"urn:xmpp:delay delay"`
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// xmppConn is a client connection with an established stream.
+type xmppConn struct {
+	conn    net.Conn
+	decoder *xml.Decoder
+	timeout time.Duration
+	jid     string
+	done    chan struct{}
+	once    sync.Once
+	mutex   sync.Mutex
+}
+
+func (c *xmppConn) send(format string, args ...interface{}) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if err := c.conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(c.conn, format, args...)
+	return err
+}
+
+func (c *xmppConn) sendMessage(to, msgType, body string) error {
+	return c.send("<message to='%s' type='%s'><body>%s</body></message>", xmlEscape(to), msgType, xmlEscape(body))
+}
+
+// next returns the next top-level element of the stream. Stream errors are returned as errors.
+func (c *xmppConn) next() (xml.StartElement, error) {
+	for {
+		token, err := c.decoder.Token()
+		if err != nil {
+			return xml.StartElement{}, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			if t.Name == (xml.Name{Space: nsXMPPStreams, Local: "error"}) {
+				var streamErr xmppError
+				if err = c.decoder.DecodeElement(&streamErr, &t); err != nil {
+					return xml.StartElement{}, err
+				}
+				return xml.StartElement{}, fmt.Errorf("stream error: %s", streamErr.String())
+			}
+			return t, nil
+		case xml.EndElement:
+			return xml.StartElement{}, io.EOF
+		}
+	}
+}
+
+// expect decodes the next element into v if it has the expected name.
+func (c *xmppConn) expect(name xml.Name, v interface{}) error {
+	start, err := c.next()
+	if err != nil {
+		return err
+	}
+	if start.Name != name {
+		return fmt.Errorf("unexpected element <%s> from server, expected <%s>", start.Name.Local, name.Local)
+	}
+	return c.decoder.DecodeElement(v, &start)
+}
+
+// openStream (re)starts the stream and returns the features offered by the server.
+func (c *xmppConn) openStream(domain string) (*xmppFeatures, error) {
+	c.decoder = xml.NewDecoder(bufio.NewReader(c.conn))
+	err := c.send("<?xml version='1.0'?><stream:stream to='%s' version='1.0' xmlns='jabber:client' xmlns:stream='%s'>", xmlEscape(domain), nsXMPPStreams)
+	if err != nil {
+		return nil, err
+	}
+	start, err := c.next()
+	if err != nil {
+		return nil, err
+	}
+	if start.Name != (xml.Name{Space: nsXMPPStreams, Local: "stream"}) {
+		return nil, fmt.Errorf("unexpected element <%s> from server, expected stream header", start.Name.Local)
+	}
+	var features xmppFeatures
+	if err = c.expect(xml.Name{Space: nsXMPPStreams, Local: "features"}, &features); err != nil {
+		return nil, err
+	}
+	return &features, nil
+}
+
+// iq sends a request and waits for the result. This must only be used while the stream is
+// being set up since there is nobody else reading from the stream.
+func (c *xmppConn) iq(id, payload string) (*xmppIQ, error) {
+	if err := c.send("<iq type='set' id='%s'>%s</iq>", id, payload); err != nil {
+		return nil, err
+	}
+	var result xmppIQ
+	if err := c.expect(xml.Name{Space: "jabber:client", Local: "iq"}, &result); err != nil {
+		return nil, err
+	}
+	if result.Type == "error" && result.Error != nil {
+		return nil, fmt.Errorf("%s request failed: %s", id, result.Error.String())
+	}
+	if result.Type != "result" || result.ID != id {
+		return nil, fmt.Errorf("%s request failed: unexpected response", id)
+	}
+	return &result, nil
+}
+
+func (c *xmppConn) close() {
+	c.once.Do(func() {
+		close(c.done)
+		c.send("</stream:stream>")
+		c.conn.Close()
+	})
+}
+
+type XMPPBackend struct {
+	infoLog   *log.Logger
+	dbgLog    *log.Logger
+	name      string
+	conf      *NotifierBackendConfigXMPP
+	commands  *commandHandler
+	body      *pongo2.Template
+	domain    string
+	username  string
+	resource  string
+	tlsConfig *tls.Config
+	conn      *xmppConn
+	mutex     *sync.RWMutex
+}
+
+func NewXMPPBackend(name string, conf *NotifierBackendConfigXMPP, commands *commandHandler, infoLog, dbgLog *log.Logger) *XMPPBackend {
+	if conf.Timeout <= 0 {
+		conf.Timeout = 10 * time.Second
+	}
+	if conf.Nick == "" {
+		conf.Nick = defaultXMPPNick
+	}
+	return &XMPPBackend{name: name, conf: conf, commands: commands, infoLog: infoLog, dbgLog: dbgLog, mutex: &sync.RWMutex{}}
+}
+
+func (xb *XMPPBackend) Init() (err error) {
+	xb.mutex.Lock()
+	defer xb.mutex.Unlock()
+
+	bare, resource, _ := strings.Cut(xb.conf.JID, "/")
+	username, domain, found := strings.Cut(bare, "@")
+	if !found || username == "" || domain == "" {
+		return fmt.Errorf("invalid JID '%s'", xb.conf.JID)
+	}
+	if resource == "" {
+		resource = defaultXMPPResource
+	}
+	xb.username, xb.domain, xb.resource = username, domain, resource
+
+	tmpl := xb.conf.Template
+	if tmpl == "" {
+		tmpl = defaultXMPPTemplate
+	}
+	if xb.body, err = pongo2.FromString(tmpl); err != nil {
+		return fmt.Errorf("failed to parse template: %v", err)
+	}
+	if xb.tlsConfig, err = xb.conf.TLS.ToGoTLSConfig(domain); err != nil {
+		return
+	}
+
+	c, err := xb.connect()
+	if err != nil {
+		return
+	}
+	xb.dbgLog.Printf("XMPP(%s): logged in as %s", xb.name, c.jid)
+	go xb.receive(c)
+	go xb.keepalive(c)
+	xb.conn = c
+	return nil
+}
+
+// server returns the address of the server. If none is configured the SRV records of the
+// domain are consulted.
+func (xb *XMPPBackend) server() string {
+	if xb.conf.Server != "" {
+		return xb.conf.Server
+	}
+	if _, addrs, err := net.LookupSRV("xmpp-client", "tcp", xb.domain); err == nil && len(addrs) > 0 && addrs[0].Target != "." {
+		return net.JoinHostPort(strings.TrimSuffix(addrs[0].Target, "."), fmt.Sprintf("%d", addrs[0].Port))
+	}
+	return net.JoinHostPort(xb.domain, "5222")
+}
+
+func (xb *XMPPBackend) connect() (*xmppConn, error) {
+	conn, err := net.DialTimeout("tcp", xb.server(), xb.conf.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &xmppConn{conn: conn, timeout: xb.conf.Timeout, done: make(chan struct{})}
+	if err = conn.SetDeadline(time.Now().Add(xb.conf.Timeout)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err = xb.login(c); err != nil {
+		c.conn.Close()
+		return nil, err
+	}
+	if err = c.conn.SetDeadline(time.Time{}); err != nil {
+		c.conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// login negotiates StartTLS, authenticates using SASL PLAIN, binds the resource and joins
+// all configured rooms. Connections which don't support StartTLS are refused since the
+// password would be sent in the clear.
+func (xb *XMPPBackend) login(c *xmppConn) error {
+	features, err := c.openStream(xb.domain)
+	if err != nil {
+		return err
+	}
+	if features.StartTLS == nil {
+		return fmt.Errorf("server does not support StartTLS")
+	}
+	if err = c.send("<starttls xmlns='%s'/>", nsXMPPTLS); err != nil {
+		return err
+	}
+	if err = c.expect(xml.Name{Space: nsXMPPTLS, Local: "proceed"}, &struct{}{}); err != nil {
+		return fmt.Errorf("StartTLS failed: %v", err)
+	}
+	tlsConn := tls.Client(c.conn, xb.tlsConfig)
+	if err = tlsConn.Handshake(); err != nil {
+		return err
+	}
+	c.conn = tlsConn
+
+	if features, err = c.openStream(xb.domain); err != nil {
+		return err
+	}
+	if features.Mechanisms == nil || !slices.Contains(features.Mechanisms.Mechanism, xmppSASLMethod) {
+		return fmt.Errorf("server does not support SASL %s authentication", xmppSASLMethod)
+	}
+	credentials := base64.StdEncoding.EncodeToString([]byte("\x00" + xb.username + "\x00" + xb.conf.Password))
+	if err = c.send("<auth xmlns='%s' mechanism='%s'>%s</auth>", nsXMPPSASL, xmppSASLMethod, credentials); err != nil {
+		return err
+	}
+	start, err := c.next()
+	if err != nil {
+		return err
+	}
+	switch start.Name {
+	case xml.Name{Space: nsXMPPSASL, Local: "success"}:
+		if err = c.decoder.Skip(); err != nil {
+			return err
+		}
+	case xml.Name{Space: nsXMPPSASL, Local: "failure"}:
+		var failure xmppError
+		if err = c.decoder.DecodeElement(&failure, &start); err != nil {
+			return err
+		}
+		return fmt.Errorf("authentication failed: %s", failure.String())
+	default:
+		return fmt.Errorf("unexpected element <%s> from server during authentication", start.Name.Local)
+	}
+
+	if features, err = c.openStream(xb.domain); err != nil {
+		return err
+	}
+	if features.Bind == nil {
+		return fmt.Errorf("server does not support resource binding")
+	}
+	result, err := c.iq("bind", fmt.Sprintf("<bind xmlns='%s'><resource>%s</resource></bind>", nsXMPPBind, xmlEscape(xb.resource)))
+	if err != nil {
+		return err
+	}
+	if result.Bind == nil || result.Bind.JID == "" {
+		return fmt.Errorf("server did not return the bound JID")
+	}
+	c.jid = result.Bind.JID
+	// session establishment is only required by old servers
+	if features.Session != nil && features.Session.Optional == nil {
+		if _, err = c.iq("session", fmt.Sprintf("<session xmlns='%s'/>", nsXMPPSession)); err != nil {
+			return err
+		}
+	}
+
+	if err = c.send("<presence/>"); err != nil {
+		return err
+	}
+	for _, room := range xb.conf.Rooms {
+		err = c.send("<presence to='%s/%s'><x xmlns='%s'><history maxstanzas='0'/></x></presence>", xmlEscape(room), xmlEscape(xb.conf.Nick), nsXMPPMUC)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// receive handles incoming stanzas until the connection is closed.
+func (xb *XMPPBackend) receive(c *xmppConn) {
+	for {
+		start, err := c.next()
+		if err != nil {
+			xb.fail(c, err)
+			return
+		}
+		switch start.Name.Local {
+		case "message":
+			var msg xmppMessage
+			if err = c.decoder.DecodeElement(&msg, &start); err == nil {
+				xb.handleMessage(c, msg)
+			}
+		case "iq":
+			var iq xmppIQ
+			if err = c.decoder.DecodeElement(&iq, &start); err == nil {
+				xb.handleIQ(c, iq)
+			}
+		default:
+			err = c.decoder.Skip()
+		}
+		if err != nil {
+			xb.fail(c, err)
+			return
+		}
+	}
+}
+
+func (xb *XMPPBackend) keepalive(c *xmppConn) {
+	ticker := time.NewTicker(xmppKeepaliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+		if err := c.send(" "); err != nil {
+			xb.fail(c, err)
+			return
+		}
+	}
+}
+
+// handleIQ answers pings and rejects all other requests.
+func (xb *XMPPBackend) handleIQ(c *xmppConn, iq xmppIQ) {
+	if iq.Type != "get" && iq.Type != "set" {
+		return
+	}
+	to := ""
+	if iq.From != "" {
+		to = fmt.Sprintf(" to='%s'", xmlEscape(iq.From))
+	}
+	var err error
+	if iq.Type == "get" && iq.Ping != nil {
+		err = c.send("<iq type='result' id='%s'%s/>", xmlEscape(iq.ID), to)
+	} else {
+		err = c.send("<iq type='error' id='%s'%s><error type='cancel'><service-unavailable xmlns='%s'/></error></iq>", xmlEscape(iq.ID), to, nsXMPPStanzas)
+	}
+	if err != nil {
+		xb.infoLog.Printf("XMPP(%s): failed to answer request: %v", xb.name, err)
+	}
+}
+
+func isXMPPCommand(text string) bool {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return false
+	}
+	switch strings.ToLower(fields[0]) {
+	case "ack", "acknowledge", "close":
+		return true
+	}
+	return false
+}
+
+// handleMessage handles chat messages of known targets as commands. In rooms only messages
+// starting with a command, optionally prefixed by "!", are considered.
+func (xb *XMPPBackend) handleMessage(c *xmppConn, msg xmppMessage) {
+	if msg.Body == "" || msg.Type == "error" || msg.Delay != nil {
+		return
+	}
+	target, exists := xb.commands.targetByXMPP(msg.From)
+	if !exists {
+		xb.dbgLog.Printf("XMPP(%s): ignoring message from unknown JID %s", xb.name, msg.From)
+		return
+	}
+
+	text := strings.TrimSpace(msg.Body)
+	to, msgType := msg.From, "chat"
+	if msg.Type == "groupchat" {
+		room, nick, _ := strings.Cut(msg.From, "/")
+		text = strings.TrimPrefix(text, "!")
+		if nick == "" || nick == xb.conf.Nick || !isXMPPCommand(text) {
+			return
+		}
+		to, msgType = room, "groupchat"
+	}
+	xb.infoLog.Printf("XMPP(%s): received command from %s: %s", xb.name, msg.From, text)
+	reply := xb.commands.handle(target, msg.From, store.SourceXMPP, text)
+	if err := c.sendMessage(to, msgType, reply); err != nil {
+		xb.infoLog.Printf("XMPP(%s): failed to send reply to %s: %v", xb.name, to, err)
+	}
+}
+
+func (xb *XMPPBackend) isRoom(jid string) bool {
+	for _, room := range xb.conf.Rooms {
+		if bareJID(room) == bareJID(jid) {
+			return true
+		}
+	}
+	return false
+}
+
+func (xb *XMPPBackend) fail(c *xmppConn, err error) {
+	xb.mutex.Lock()
+	defer xb.mutex.Unlock()
+
+	if xb.conn != c {
+		return
+	}
+	if errors.Is(err, io.EOF) {
+		err = errors.New("stream closed by server")
+	}
+	xb.infoLog.Printf("XMPP(%s): connection lost: %v", xb.name, err)
+	xb.close()
+}
+
+func (xb *XMPPBackend) ready() bool {
+	return xb.conn != nil
+}
+
+func (xb *XMPPBackend) Ready() bool {
+	xb.mutex.RLock()
+	defer xb.mutex.RUnlock()
+
+	return xb.ready()
+}
+
+func (xb *XMPPBackend) Notify(ctx context.Context, target NotifierTarget, notification *Notification) (bool, error) {
+	xb.mutex.RLock()
+	defer xb.mutex.RUnlock()
+
+	if target.XMPP == nil {
+		return false, nil
+	}
+	if !xb.ready() {
+		return false, errBackendNotReady
+	}
+	text, err := xb.body.Execute(notification.templateContext())
+	if err != nil {
+		return false, err
+	}
+	to, msgType := string(*target.XMPP), "chat"
+	if xb.isRoom(to) {
+		msgType = "groupchat"
+	}
+	if err = xb.conn.sendMessage(to, msgType, strings.TrimSpace(text)); err != nil {
+		// a partially written stanza breaks the stream
+		go xb.fail(xb.conn, err)
+		return false, err
+	}
+	return true, nil
+}
+
+func (xb *XMPPBackend) close() {
+	if xb.conn != nil {
+		xb.conn.close()
+	}
+	xb.conn = nil
+}
+
+func (xb *XMPPBackend) Close() error {
+	xb.mutex.Lock()
+	defer xb.mutex.Unlock()
+
+	xb.close()
+	return nil
+}
@@ -0,0 +1,328 @@
+//
+// Copyright (c) 2023 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.alerts nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package notifier
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/whawty/alerts/store"
+)
+
+type testXMPPStanza struct {
+	name string
+	to   string
+	typ  string
+	id   string
+	body string
+}
+
+// testXMPPServer requires StartTLS and SASL PLAIN authentication as alerts@example.com with
+// password "secret". Once a client has bound its resource all stanzas sent by it are recorded.
+type testXMPPServer struct {
+	listener net.Listener
+	cert     tls.Certificate
+	startTLS bool
+
+	mutex   sync.Mutex
+	conn    net.Conn
+	stanzas []testXMPPStanza
+}
+
+func newTestXMPPServer(t *testing.T, cert tls.Certificate, startTLS bool) *testXMPPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	s := &testXMPPServer{listener: listener, cert: cert, startTLS: startTLS}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.handle(conn)
+		}
+	}()
+	return s
+}
+
+func (s *testXMPPServer) nextElement(decoder *xml.Decoder) (xml.StartElement, error) {
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return xml.StartElement{}, err
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			return start, nil
+		}
+	}
+}
+
+// openStream waits for the stream header of the client and answers it with features.
+func (s *testXMPPServer) openStream(conn net.Conn, features string) (*xml.Decoder, error) {
+	decoder := xml.NewDecoder(conn)
+	start, err := s.nextElement(decoder)
+	if err != nil {
+		return nil, err
+	}
+	if start.Name != (xml.Name{Space: nsXMPPStreams, Local: "stream"}) {
+		return nil, fmt.Errorf("unexpected element <%s>", start.Name.Local)
+	}
+	_, err = fmt.Fprintf(conn, "<?xml version='1.0'?><stream:stream xmlns='jabber:client' xmlns:stream='%s' id='test' from='example.com' version='1.0'><stream:features>%s</stream:features>", nsXMPPStreams, features)
+	return decoder, err
+}
+
+func (s *testXMPPServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	if !s.startTLS {
+		if _, err := s.openStream(conn, fmt.Sprintf("<mechanisms xmlns='%s'><mechanism>PLAIN</mechanism></mechanisms>", nsXMPPSASL)); err == nil {
+			io.Copy(io.Discard, conn)
+		}
+		return
+	}
+	decoder, err := s.openStream(conn, fmt.Sprintf("<starttls xmlns='%s'><required/></starttls>", nsXMPPTLS))
+	if err != nil {
+		return
+	}
+	if start, err := s.nextElement(decoder); err != nil || start.Name.Local != "starttls" {
+		return
+	}
+	fmt.Fprintf(conn, "<proceed xmlns='%s'/>", nsXMPPTLS)
+	tlsConn := tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{s.cert}})
+	if err = tlsConn.Handshake(); err != nil {
+		return
+	}
+	conn = tlsConn
+
+	if decoder, err = s.openStream(conn, fmt.Sprintf("<mechanisms xmlns='%s'><mechanism>SCRAM-SHA-1</mechanism><mechanism>PLAIN</mechanism></mechanisms>", nsXMPPSASL)); err != nil {
+		return
+	}
+	start, err := s.nextElement(decoder)
+	if err != nil {
+		return
+	}
+	var auth struct {
+		Mechanism string `xml:"mechanism,attr"`
+		Data      string `xml:",chardata"`
+	}
+	if err = decoder.DecodeElement(&auth, &start); err != nil {
+		return
+	}
+	if credentials, _ := base64.StdEncoding.DecodeString(auth.Data); auth.Mechanism != "PLAIN" || string(credentials) != "\x00alerts\x00secret" {
+		fmt.Fprintf(conn, "<failure xmlns='%s'><not-authorized/><text>invalid credentials</text></failure></stream:stream>", nsXMPPSASL)
+		return
+	}
+	fmt.Fprintf(conn, "<success xmlns='%s'/>", nsXMPPSASL)
+
+	if decoder, err = s.openStream(conn, fmt.Sprintf("<bind xmlns='%s'/><session xmlns='%s'><optional/></session>", nsXMPPBind, nsXMPPSession)); err != nil {
+		return
+	}
+	if start, err = s.nextElement(decoder); err != nil {
+		return
+	}
+	var bind struct {
+		ID       string `xml:"id,attr"`
+		Resource string `xml:"urn:ietf:params:xml:ns:xmpp-bind bind>resource"`
+	}
+	if err = decoder.DecodeElement(&bind, &start); err != nil {
+		return
+	}
+	s.mutex.Lock()
+	s.conn = conn
+	fmt.Fprintf(conn, "<iq type='result' id='%s'><bind xmlns='%s'><jid>alerts@example.com/%s</jid></bind></iq>", bind.ID, nsXMPPBind, bind.Resource)
+	s.mutex.Unlock()
+
+	for {
+		if start, err = s.nextElement(decoder); err != nil {
+			return
+		}
+		var stanza struct {
+			To   string `xml:"to,attr"`
+			Type string `xml:"type,attr"`
+			ID   string `xml:"id,attr"`
+			Body string `xml:"body"`
+		}
+		if err = decoder.DecodeElement(&stanza, &start); err != nil {
+			return
+		}
+		s.mutex.Lock()
+		s.stanzas = append(s.stanzas, testXMPPStanza{name: start.Name.Local, to: stanza.To, typ: stanza.Type, id: stanza.ID, body: stanza.Body})
+		s.mutex.Unlock()
+	}
+}
+
+// send writes raw XML to the client which has logged in last.
+func (s *testXMPPServer) send(t *testing.T, format string, args ...interface{}) {
+	t.Helper()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, err := fmt.Fprintf(s.conn, format, args...); err != nil {
+		t.Fatalf("failed to send to client: %v", err)
+	}
+}
+
+func (s *testXMPPServer) received(name string) (stanzas []testXMPPStanza) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, stanza := range s.stanzas {
+		if stanza.name == name {
+			stanzas = append(stanzas, stanza)
+		}
+	}
+	return
+}
+
+func newTestXMPPBackend(server *testXMPPServer, caFile, password string, commands *commandHandler) *XMPPBackend {
+	conf := &NotifierBackendConfigXMPP{
+		JID:      "alerts@example.com",
+		Password: password,
+		Server:   server.listener.Addr().String(),
+		Timeout:  5 * time.Second,
+		TLS:      &TLSClientConfig{CACertificates: caFile, ServerName: "127.0.0.1"},
+		Rooms:    []string{"ops@conference.example.com"},
+		Nick:     "alerts",
+	}
+	return NewXMPPBackend("test", conf, commands, testLog, testLog)
+}
+
+func TestXMPPBackendLogin(t *testing.T) {
+	cert, caFile := testCertificate(t)
+
+	plain := newTestXMPPServer(t, cert, false)
+	if err := newTestXMPPBackend(plain, caFile, "secret", nil).Init(); err == nil || !strings.Contains(err.Error(), "StartTLS") {
+		t.Fatalf("expected servers without StartTLS to be refused, got %v", err)
+	}
+
+	server := newTestXMPPServer(t, cert, true)
+	if err := newTestXMPPBackend(server, caFile, "wrong", nil).Init(); err == nil || !strings.Contains(err.Error(), "not-authorized: invalid credentials") {
+		t.Fatalf("expected the server to reject wrong credentials, got %v", err)
+	}
+
+	xb := newTestXMPPBackend(server, caFile, "secret", nil)
+	if err := xb.Init(); err != nil {
+		t.Fatalf("failed to initialize backend: %v", err)
+	}
+	defer xb.Close()
+	waitFor(t, "presences", func() bool { return len(server.received("presence")) == 2 })
+	if presences := server.received("presence"); presences[0].to != "" || presences[1].to != "ops@conference.example.com/alerts" {
+		t.Errorf("expected initial presence and room join, got %+v", presences)
+	}
+
+	// pings are answered, the backend fails once the server closes the stream
+	server.send(t, "<iq type='get' id='ping1' from='example.com'><ping xmlns='%s'/></iq>", nsXMPPPing)
+	waitFor(t, "ping response", func() bool { return len(server.received("iq")) == 1 })
+	if iq := server.received("iq")[0]; iq.typ != "result" || iq.id != "ping1" || iq.to != "example.com" {
+		t.Errorf("unexpected ping response: %+v", iq)
+	}
+	server.send(t, "</stream:stream>")
+	waitFor(t, "backend to fail", func() bool { return !xb.Ready() })
+}
+
+func TestXMPPBackendNotifyAndCommands(t *testing.T) {
+	cert, caFile := testCertificate(t)
+	server := newTestXMPPServer(t, cert, true)
+	st := newTestStore(t)
+	hugo, room := NotifierTargetXMPP("hugo@example.com"), NotifierTargetXMPP("ops@conference.example.com")
+	targets := []NotifierTarget{{Name: "hugo", XMPP: &hugo}, {Name: "ops", XMPP: &room}}
+
+	xb := newTestXMPPBackend(server, caFile, "secret", newCommandHandler(st, targets, testLog))
+	if err := xb.Init(); err != nil {
+		t.Fatalf("failed to initialize backend: %v", err)
+	}
+	defer xb.Close()
+
+	disk, _, err := st.CreateAlert(&store.Alert{Name: "disk <full>"})
+	if err != nil {
+		t.Fatalf("failed to create alert: %v", err)
+	}
+	load, _, err := st.CreateAlert(&store.Alert{Name: "load high"})
+	if err != nil {
+		t.Fatalf("failed to create alert: %v", err)
+	}
+	if sent, err := xb.Notify(context.Background(), NotifierTarget{Name: "email-only"}, &Notification{Alerts: []*store.Alert{disk}}); sent || err != nil {
+		t.Fatalf("targets without JID must be skipped, got %t, %v", sent, err)
+	}
+	for _, target := range targets {
+		if sent, err := xb.Notify(context.Background(), target, &Notification{Alerts: []*store.Alert{disk}}); err != nil || !sent {
+			t.Fatalf("failed to send notification to %s: %v", target.Name, err)
+		}
+	}
+	waitFor(t, "notifications", func() bool { return len(server.received("message")) == 2 })
+	messages := server.received("message")
+	if messages[0].to != "hugo@example.com" || messages[0].typ != "chat" || messages[1].to != "ops@conference.example.com" || messages[1].typ != "groupchat" {
+		t.Errorf("unexpected recipients or message types: %+v", messages)
+	}
+	if !strings.Contains(messages[0].body, "disk <full> ("+disk.ShortID()+")") {
+		t.Errorf("unexpected message body: %s", messages[0].body)
+	}
+
+	stateOf := func(id string) store.AlertState {
+		alert, err := st.GetAlert(id)
+		if err != nil {
+			t.Fatalf("failed to get alert: %v", err)
+		}
+		return alert.State
+	}
+	server.send(t, "<message from='mallory@example.com/pc' type='chat'><body>close %s</body></message>", disk.ShortID())
+	server.send(t, "<message from='hugo@example.com/phone' type='chat'><body>ack %s</body></message>", disk.ShortID())
+	waitFor(t, "alert to be acknowledged", func() bool { return stateOf(disk.ID) == store.StateAcknowledged })
+
+	// echos of our own messages, room history and chatter are ignored in rooms
+	server.send(t, "<message from='ops@conference.example.com/alerts' type='groupchat'><body>!close %s</body></message>", disk.ShortID())
+	server.send(t, "<message from='ops@conference.example.com/hugo' type='groupchat'><body>close %s</body><delay xmlns='%s' stamp='2023-01-01T00:00:00Z'/></message>", disk.ShortID(), nsXMPPDelay)
+	server.send(t, "<message from='ops@conference.example.com/hugo' type='groupchat'><body>closing in on it</body></message>")
+	server.send(t, "<message from='ops@conference.example.com/hugo' type='groupchat'><body>!ack %s</body></message>", load.ShortID())
+	waitFor(t, "alert to be acknowledged", func() bool { return stateOf(load.ID) == store.StateAcknowledged })
+	if state := stateOf(disk.ID); state != store.StateAcknowledged {
+		t.Errorf("ignored message changed the alert to %s", state)
+	}
+
+	waitFor(t, "replies", func() bool { return len(server.received("message")) == 4 })
+	replies := server.received("message")[2:]
+	if replies[0].to != "hugo@example.com/phone" || replies[0].typ != "chat" || !strings.Contains(replies[0].body, "'"+disk.ShortID()+"' (disk <full>) is now acknowledged") {
+		t.Errorf("unexpected reply to chat command: %+v", replies[0])
+	}
+	if replies[1].to != "ops@conference.example.com" || replies[1].typ != "groupchat" || !strings.Contains(replies[1].body, "'"+load.ShortID()+"' (load high) is now acknowledged") {
+		t.Errorf("unexpected reply to room command: %+v", replies[1])
+	}
+}
@@ -0,0 +1,182 @@
+//
+// Copyright (c) 2023 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.alerts nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package notifier
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/whawty/alerts/store"
+)
+
+// commandHandler processes commands which are sent back to us by notification targets
+// via backends that support inbound messages.
+type commandHandler struct {
+	store   *store.Store
+	infoLog *log.Logger
+	targets []NotifierTarget
+	mutex   sync.Mutex
+	last    map[string][]string
+}
+
+func newCommandHandler(st *store.Store, targets []NotifierTarget, infoLog *log.Logger) *commandHandler {
+	return &commandHandler{store: st, infoLog: infoLog, targets: targets, last: make(map[string][]string)}
+}
+
+func normalizePhoneNumber(number string) string {
+	number = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(" -/()", r) {
+			return -1
+		}
+		return r
+	}, number)
+	if strings.HasPrefix(number, "00") {
+		number = "+" + number[2:]
+	}
+	return strings.TrimPrefix(number, "+")
+}
+
+func (h *commandHandler) targetByMatrixRoom(room string) (NotifierTarget, bool) {
+	for _, target := range h.targets {
+		if target.Matrix != nil && string(*target.Matrix) == room {
+			return target, true
+		}
+	}
+	return NotifierTarget{}, false
+}
+
+func (h *commandHandler) targetByTelegramChat(chat string) (NotifierTarget, bool) {
+	for _, target := range h.targets {
+		if target.Telegram != nil && string(*target.Telegram) == chat {
+			return target, true
+		}
+	}
+	return NotifierTarget{}, false
+}
+
+// bareJID strips the resource from jid. The local and domain parts are case-insensitive.
+func bareJID(jid string) string {
+	bare, _, _ := strings.Cut(jid, "/")
+	return strings.ToLower(bare)
+}
+
+func (h *commandHandler) targetByXMPP(jid string) (NotifierTarget, bool) {
+	jid = bareJID(jid)
+	for _, target := range h.targets {
+		if target.XMPP != nil && bareJID(string(*target.XMPP)) == jid {
+			return target, true
+		}
+	}
+	return NotifierTarget{}, false
+}
+
+func (h *commandHandler) targetBySMS(number string) (NotifierTarget, bool) {
+	number = normalizePhoneNumber(number)
+	for _, target := range h.targets {
+		if target.SMS != nil && normalizePhoneNumber(string(*target.SMS)) == number {
+			return target, true
+		}
+	}
+	return NotifierTarget{}, false
+}
+
+// notified remembers the alerts of the last notification sent to target. Commands which
+// don't specify an alert ID refer to all of these alerts.
+func (h *commandHandler) notified(target NotifierTarget, notification *Notification) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	ids := make([]string, 0, len(notification.Alerts))
+	for _, alert := range notification.Alerts {
+		ids = append(ids, alert.ID)
+	}
+	h.last[target.Name] = ids
+}
+
+func (h *commandHandler) lastNotified(target NotifierTarget) ([]string, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	ids, exists := h.last[target.Name]
+	return ids, exists
+}
+
+// handle executes the command on behalf of actor and returns a message which should be sent
+// back to target. Supported commands are "ack [<short-id>]" and "close [<short-id>]". Without
+// an ID the command applies to all alerts of the last notification sent to target.
+func (h *commandHandler) handle(target NotifierTarget, actor string, source store.StateChangeSource, text string) string {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || len(fields) > 2 {
+		return "invalid command, use: ack|close [<id>]"
+	}
+
+	var state store.AlertState
+	switch strings.ToLower(fields[0]) {
+	case "ack", "acknowledge":
+		state = store.StateAcknowledged
+	case "close":
+		state = store.StateClosed
+	default:
+		return fmt.Sprintf("unknown command '%s', use: ack|close [<id>]", fields[0])
+	}
+
+	var ids []string
+	if len(fields) > 1 {
+		alert, err := h.store.GetAlertByShortID(fields[1])
+		if err != nil {
+			return fmt.Sprintf("alert '%s': %v", fields[1], err)
+		}
+		ids = []string{alert.ID}
+	} else {
+		var exists bool
+		if ids, exists = h.lastNotified(target); !exists {
+			return "no alert has been sent to you recently, please specify an alert ID"
+		}
+	}
+	return h.setStates(ids, state, actor, source)
+}
+
+// setStates changes the state of all alerts and returns a message describing the results.
+func (h *commandHandler) setStates(ids []string, state store.AlertState, actor string, source store.StateChangeSource) string {
+	replies := make([]string, 0, len(ids))
+	for _, id := range ids {
+		alert, err := h.store.SetAlertState(id, state, actor, source)
+		if err != nil {
+			replies = append(replies, fmt.Sprintf("alert '%s': %v", store.Alert{ID: id}.ShortID(), err))
+			continue
+		}
+		h.infoLog.Printf("notifier: alert %s has been set to state '%s' by '%s' via %s", alert.ID, alert.State, actor, source)
+		replies = append(replies, fmt.Sprintf("%s alert '%s' (%s) is now %s", alert.State.Emoji(), alert.ShortID(), alert.Name, alert.State))
+	}
+	return strings.Join(replies, "\n")
+}
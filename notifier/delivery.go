@@ -0,0 +1,254 @@
+//
+// Copyright (c) 2023 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.alerts nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package notifier
+
+import (
+	"sort"
+	"time"
+
+	"github.com/whawty/alerts/store"
+)
+
+type deliveryKey struct {
+	target  string
+	backend string
+}
+
+type delivery struct {
+	target       NotifierTarget
+	backend      string
+	notification *Notification
+}
+
+type deliveryResult uint
+
+const (
+	deliverySkipped deliveryResult = iota
+	deliverySent
+	deliverySuppressed
+	deliveryDeferred
+	deliveryFailed
+)
+
+// deliveryState tracks the attempts to deliver an alert to a target via a backend until the
+// alert is marked as notified.
+type deliveryState struct {
+	attempts  uint
+	next      time.Time
+	done      bool
+	delivered bool
+}
+
+type deliveryStatus uint
+
+const (
+	deliveryDue deliveryStatus = iota
+	deliveryWaiting
+	deliveryDone
+)
+
+// deliveryKeys returns all combinations of targets and backends the receivers need to be
+// notified with. Receivers without backends get notified via all backends.
+func (n *Notifier) deliveryKeys(receivers []receiver) (keys []deliveryKey) {
+	done := make(map[deliveryKey]bool)
+	for _, r := range receivers {
+		names := r.backends
+		if len(names) == 0 {
+			for name := range n.backends {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+		}
+		for _, name := range names {
+			key := deliveryKey{target: r.target.Name, backend: name}
+			if !done[key] {
+				done[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	return
+}
+
+// nextDelivery returns the backend which should be used next to deliver the alert to the
+// target. Once all attempts via a backend have failed its fallback is used instead.
+func (n *Notifier) nextDelivery(id string, key deliveryKey, now time.Time) (deliveryKey, deliveryStatus) {
+	visited := make(map[string]bool)
+	for {
+		visited[key.backend] = true
+		state, exists := n.pending[id][key]
+		switch {
+		case !exists:
+			return key, deliveryDue
+		case state.done:
+			return key, deliveryDone
+		case state.attempts < n.conf.DeliveryRetry.MaxAttempts:
+			if now.Before(state.next) {
+				return key, deliveryWaiting
+			}
+			return key, deliveryDue
+		}
+		fallback := n.fallbacks[key.backend]
+		if fallback == "" || visited[fallback] {
+			return key, deliveryDone
+		}
+		key.backend = fallback
+	}
+}
+
+func (n *Notifier) deliveriesDone(due *dueAlert, now time.Time) bool {
+	for _, key := range n.deliveryKeys(due.receivers) {
+		if _, status := n.nextDelivery(due.alert.ID, key, now); status != deliveryDone {
+			return false
+		}
+	}
+	return true
+}
+
+// delivered returns whether the alert has been delivered to at least one target and whether
+// there has been any attempt to do so.
+func (n *Notifier) delivered(id string) (delivered, attempted bool) {
+	for _, state := range n.pending[id] {
+		delivered = delivered || state.delivered
+		attempted = attempted || state.attempts > 0
+	}
+	return
+}
+
+func (n *Notifier) deliveryBackoff(attempts uint) time.Duration {
+	backoff := n.conf.DeliveryRetry.MinBackoff
+	for i := uint(1); i < attempts && backoff < n.conf.DeliveryRetry.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > n.conf.DeliveryRetry.MaxBackoff {
+		backoff = n.conf.DeliveryRetry.MaxBackoff
+	}
+	return backoff
+}
+
+// recordDelivery updates the delivery state of the alert and adds the attempt to the delivery
+// log of the alert.
+func (n *Notifier) recordDelivery(alert *store.Alert, d *delivery, result deliveryResult, err error, now time.Time) {
+	key := deliveryKey{target: d.target.Name, backend: d.backend}
+	if n.pending[alert.ID] == nil {
+		n.pending[alert.ID] = make(map[deliveryKey]*deliveryState)
+	}
+	state := n.pending[alert.ID][key]
+	if state == nil {
+		state = &deliveryState{}
+		n.pending[alert.ID][key] = state
+	}
+
+	record := &store.Delivery{Timestamp: now, Alert: alert.ID, Target: d.target.Name, Backend: d.backend}
+	switch result {
+	case deliverySkipped:
+		// the backend can't reach the target, nothing has been attempted
+		state.done = true
+		return
+	case deliveryDeferred:
+		// rate limits will allow this later on, this does not count as an attempt
+		return
+	case deliverySent:
+		state.done = true
+		state.delivered = true
+		record.Outcome = store.DeliverySent
+	case deliverySuppressed:
+		// the alert will be part of the summary sent once the rate limit allows it
+		state.done = true
+		state.delivered = true
+		record.Outcome = store.DeliverySuppressed
+	case deliveryFailed:
+		state.next = now.Add(n.deliveryBackoff(state.attempts + 1))
+		record.Outcome = store.DeliveryFailed
+		record.Error = err.Error()
+	}
+	state.attempts++
+	record.Attempt = state.attempts
+
+	if result == deliveryFailed && state.attempts >= n.conf.DeliveryRetry.MaxAttempts {
+		if fallback := n.fallbacks[d.backend]; fallback != "" {
+			n.infoLog.Printf("notifier: all %d attempts to notify '%s' about alert %s via backend '%s' have failed, falling back to backend '%s'", state.attempts, d.target.Name, alert.ID, d.backend, fallback)
+		} else {
+			n.infoLog.Printf("notifier: all %d attempts to notify '%s' about alert %s via backend '%s' have failed, giving up", state.attempts, d.target.Name, alert.ID, d.backend)
+		}
+	}
+	if err := n.store.AddAlertDelivery(record); err != nil {
+		n.infoLog.Printf("notifier: failed to record delivery of alert %s: %v", alert.ID, err)
+	}
+}
+
+// deliver sends the notification unless this is prevented by rate limits. Alerts which have
+// been suppressed by the rate limits before are reported as part of the notification.
+func (n *Notifier) deliver(d *delivery, now time.Time) (deliveryResult, error) {
+	limiters := n.rateLimiters(d.target.Name, d.backend, now)
+	defer n.saveRateLimiters(limiters)
+
+	for _, l := range limiters {
+		if l.available() {
+			continue
+		}
+		switch l.conf.Overflow {
+		case RateLimitDefer:
+			n.dbgLog.Printf("notifier: rate limit '%s' defers notification about %s to '%s' via backend '%s'", l.key, d.notification, d.target.Name, d.backend)
+			return deliveryDeferred, nil
+		case RateLimitSummarize:
+			l.suppress(d.target.Name, d.backend, uint(len(d.notification.Alerts)))
+		}
+		n.infoLog.Printf("notifier: rate limit '%s' suppressed notification about %s to '%s' via backend '%s'", l.key, d.notification, d.target.Name, d.backend)
+		return deliverySuppressed, nil
+	}
+
+	for _, l := range limiters {
+		d.notification.Suppressed += l.suppressed(d.target.Name, d.backend)
+	}
+	if len(d.notification.Alerts) == 0 && d.notification.Suppressed == 0 {
+		return deliverySkipped, nil
+	}
+
+	ok, err := n.backends[d.backend].Notify(n.ctx, d.target, d.notification)
+	if err != nil {
+		n.infoLog.Printf("notifier: failed to notify '%s' about %s via backend '%s': %v", d.target.Name, d.notification, d.backend, err)
+		return deliveryFailed, err
+	}
+	if !ok {
+		return deliverySkipped, nil
+	}
+	n.infoLog.Printf("notifier: sent notification about %s to '%s' via backend '%s'", d.notification, d.target.Name, d.backend)
+	if len(d.notification.Alerts) > 0 {
+		n.commands.notified(d.target, d.notification)
+		n.trackFollowUps(d)
+	}
+	for _, l := range limiters {
+		l.state.Tokens--
+		l.reported(d.target.Name, d.backend)
+	}
+	return deliverySent, nil
+}
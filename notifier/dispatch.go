@@ -0,0 +1,252 @@
+//
+// Copyright (c) 2023 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.alerts nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package notifier
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/whawty/alerts/store"
+)
+
+func (n *Notifier) notificationDue(alert *store.Alert, now time.Time) bool {
+	if alert.NotifiedAt == nil {
+		return true
+	}
+	if n.conf.RenotifyInterval <= 0 {
+		return false
+	}
+	return !now.Before(alert.NotifiedAt.Add(n.conf.RenotifyInterval))
+}
+
+type receiver struct {
+	target   NotifierTarget
+	backends []string
+}
+
+// receivers returns everybody who needs to be notified about the alert right now according
+// to the routes matching the alert.
+func (n *Notifier) receivers(alert *store.Alert, now time.Time) ([]receiver, map[string]int) {
+	var receivers []receiver
+	escalation := make(map[string]int)
+	for _, result := range n.route(alert) {
+		if result.policy != "" {
+			if _, done := escalation[result.policy]; done {
+				continue
+			}
+			r, notified := n.escalate(alert, n.policies[result.policy], now)
+			escalation[result.policy] = notified
+			receivers = append(receivers, r...)
+			continue
+		}
+		if n.notificationDue(alert, now) {
+			for _, target := range result.targets {
+				receivers = append(receivers, receiver{target: n.targets[target], backends: result.backends})
+			}
+		}
+	}
+	return receivers, escalation
+}
+
+func silencedBy(silences []store.Silence, alert *store.Alert, now time.Time) *store.Silence {
+	for idx := range silences {
+		if silences[idx].Silences(alert, now) {
+			return &silences[idx]
+		}
+	}
+	return nil
+}
+
+// dueAlert is an alert which needs to be sent to the receivers right now.
+type dueAlert struct {
+	alert      *store.Alert
+	receivers  []receiver
+	escalation map[string]int
+}
+
+// groupLabels returns the values of all labels the alert is grouped by.
+func (n *Notifier) groupLabels(alert *store.Alert) map[string]string {
+	labels := make(map[string]string)
+	for _, name := range n.conf.Group.By {
+		labels[name] = alert.LabelValue(name)
+	}
+	return labels
+}
+
+func (n *Notifier) groupKey(alert *store.Alert) string {
+	var key strings.Builder
+	for _, name := range n.conf.Group.By {
+		key.WriteString(strconv.Quote(alert.LabelValue(name)))
+		key.WriteByte(',')
+	}
+	return key.String()
+}
+
+// groupReady returns true if the group window has passed since the first alert of the
+// group became due.
+func (n *Notifier) groupReady(group []*dueAlert, now time.Time) bool {
+	for _, due := range group {
+		if !now.Before(n.dueSince[due.alert.ID].Add(n.conf.Group.Wait)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *Notifier) dispatch() {
+	n.sendFollowUps()
+
+	// acknowledged alerts are still active and might inhibit other alerts
+	alerts, err := n.store.ListAlertsByState(store.StateNew, store.StateOpen, store.StateAcknowledged)
+	if err != nil {
+		n.infoLog.Printf("notifier: failed to list alerts: %v", err)
+		return
+	}
+
+	silences, err := n.store.ListSilences(-1, -1)
+	if err != nil {
+		n.infoLog.Printf("notifier: failed to list silences: %v", err)
+		return
+	}
+
+	now := time.Now()
+	var keys []string
+	groups := make(map[string][]*dueAlert)
+	for idx := range alerts {
+		alert := &alerts[idx]
+		if alert.State == store.StateAcknowledged {
+			continue
+		}
+		if silence := silencedBy(silences, alert, now); silence != nil {
+			n.dbgLog.Printf("notifier: alert %s is silenced by %s", alert.ID, silence.ID)
+			continue
+		}
+		if source := n.inhibitedBy(alerts, alert); source != nil {
+			n.dbgLog.Printf("notifier: alert %s is inhibited by alert %s", alert.ID, source.ID)
+			continue
+		}
+		receivers, escalation := n.receivers(alert, now)
+		if len(receivers) == 0 {
+			continue
+		}
+		key := n.groupKey(alert)
+		if _, exists := groups[key]; !exists {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], &dueAlert{alert: alert, receivers: receivers, escalation: escalation})
+	}
+
+	due := make(map[string]time.Time)
+	for _, group := range groups {
+		for _, d := range group {
+			since, exists := n.dueSince[d.alert.ID]
+			if !exists {
+				since = now
+			}
+			due[d.alert.ID] = since
+		}
+	}
+	// alerts which are no longer due (i.e. because they got acknowledged) start over
+	n.dueSince = due
+	for id := range n.pending {
+		if _, exists := due[id]; !exists {
+			delete(n.pending, id)
+		}
+	}
+
+	for _, key := range keys {
+		group := groups[key]
+		if !n.groupReady(group, now) {
+			n.dbgLog.Printf("notifier: waiting for more alerts to join group of %d alert(s)", len(group))
+			continue
+		}
+		sort.SliceStable(group, func(i, j int) bool { return group[i].alert.Severity < group[j].alert.Severity })
+		n.flush(group, now)
+	}
+	n.flushSuppressed(now)
+}
+
+// flush sends one notification per target and backend containing all alerts of the group
+// which need to be sent there. Alerts are marked as notified once there is nothing left to
+// be delivered for them and at least one target has been notified.
+func (n *Notifier) flush(group []*dueAlert, now time.Time) {
+	var deliveries []*delivery
+	index := make(map[deliveryKey]*delivery)
+	for _, due := range group {
+		for _, key := range n.deliveryKeys(due.receivers) {
+			key, status := n.nextDelivery(due.alert.ID, key, now)
+			if status != deliveryDue {
+				continue
+			}
+			d, exists := index[key]
+			if !exists {
+				d = &delivery{target: n.targets[key.target], backend: key.backend, notification: &Notification{GroupLabels: n.groupLabels(due.alert)}}
+				index[key] = d
+				deliveries = append(deliveries, d)
+			}
+			alerts := d.notification.Alerts
+			if len(alerts) > 0 && alerts[len(alerts)-1] == due.alert {
+				continue
+			}
+			d.notification.Alerts = append(alerts, due.alert)
+		}
+	}
+
+	for _, d := range deliveries {
+		result, err := n.deliver(d, now)
+		for _, alert := range d.notification.Alerts {
+			n.recordDelivery(alert, d, result, err, now)
+		}
+	}
+
+	for _, due := range group {
+		if !n.deliveriesDone(due, now) {
+			continue
+		}
+		delivered, attempted := n.delivered(due.alert.ID)
+		delete(n.pending, due.alert.ID)
+		if !delivered {
+			// the alert stays due, all deliveries will be tried again
+			if attempted {
+				n.infoLog.Printf("notifier: alert %s could not be delivered to any target, starting over", due.alert.ID)
+			} else {
+				n.dbgLog.Printf("notifier: none of the targets of alert %s can be reached via their backends", due.alert.ID)
+			}
+			continue
+		}
+		delete(n.dueSince, due.alert.ID)
+		if _, err := n.store.MarkAlertNotified(due.alert.ID, due.escalation); err != nil {
+			n.infoLog.Printf("notifier: failed to mark alert %s as notified: %v", due.alert.ID, err)
+		}
+	}
+}
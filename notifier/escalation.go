@@ -0,0 +1,117 @@
+//
+// Copyright (c) 2023 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.alerts nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package notifier
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/whawty/alerts/store"
+)
+
+func (n *Notifier) checkEscalationPolicies() error {
+	n.policies = make(map[string]*EscalationPolicy)
+	for idx := range n.conf.EscalationPolicies {
+		policy := &n.conf.EscalationPolicies[idx]
+		if policy.Name == "" {
+			return fmt.Errorf("found unnamed escalation policy at config index %d", idx)
+		}
+		if _, exists := n.policies[policy.Name]; exists {
+			return fmt.Errorf("found duplicate escalation policy name at config index %d", idx)
+		}
+		n.policies[policy.Name] = policy
+
+		if len(policy.Steps) == 0 {
+			return fmt.Errorf("escalation policy '%s' has no steps", policy.Name)
+		}
+		for sIdx, step := range policy.Steps {
+			if sIdx > 0 && step.Delay < policy.Steps[sIdx-1].Delay {
+				return fmt.Errorf("escalation policy '%s': steps must be ordered by delay", policy.Name)
+			}
+			if len(step.Targets) == 0 {
+				return fmt.Errorf("escalation policy '%s': step %d has no targets", policy.Name, sIdx)
+			}
+			if err := n.checkReceivers(step.Targets, step.Backends); err != nil {
+				return fmt.Errorf("escalation policy '%s': step %d: %v", policy.Name, sIdx, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (n *Notifier) checkReceivers(targets, backends []string) error {
+	for _, target := range targets {
+		if _, exists := n.targets[target]; !exists {
+			return fmt.Errorf("unknown target '%s'", target)
+		}
+	}
+	for _, backend := range backends {
+		if _, exists := n.backends[backend]; !exists {
+			return fmt.Errorf("unknown backend '%s'", backend)
+		}
+	}
+	return nil
+}
+
+func (n *Notifier) stepReceivers(step EscalationStep) (receivers []receiver) {
+	for _, target := range step.Targets {
+		receivers = append(receivers, receiver{target: n.targets[target], backends: step.Backends})
+	}
+	return
+}
+
+// escalate returns the receivers of all steps of the policy which became due since the last
+// notification of the alert, together with the resulting number of notified steps. Once no
+// further steps are due, all receivers of steps which have already been notified are
+// re-notified according to the re-notification interval.
+func (n *Notifier) escalate(alert *store.Alert, policy *EscalationPolicy, now time.Time) ([]receiver, int) {
+	notified := alert.Escalation[policy.Name]
+	activated := alert.ActivatedAt
+	if activated.IsZero() {
+		activated = alert.CreatedAt
+	}
+
+	var receivers []receiver
+	for notified < len(policy.Steps) {
+		step := policy.Steps[notified]
+		if now.Before(activated.Add(step.Delay)) {
+			break
+		}
+		receivers = append(receivers, n.stepReceivers(step)...)
+		notified++
+	}
+	if len(receivers) == 0 && notified > 0 && n.notificationDue(alert, now) {
+		for _, step := range policy.Steps[:notified] {
+			receivers = append(receivers, n.stepReceivers(step)...)
+		}
+	}
+	return receivers, notified
+}
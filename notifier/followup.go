@@ -0,0 +1,105 @@
+//
+// Copyright (c) 2023 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.alerts nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package notifier
+
+import (
+	"errors"
+
+	"github.com/whawty/alerts/store"
+)
+
+// followUp remembers the state of an alert at the time it has been sent to a target via a
+// backend which supports follow-up messages. This is only kept in memory so follow-ups for
+// notifications sent before a restart won't happen.
+type followUp struct {
+	key   deliveryKey
+	state store.AlertState
+}
+
+func (n *Notifier) trackFollowUps(d *delivery) {
+	if _, ok := n.backends[d.backend].(NotifierBackendFollowUp); !ok {
+		return
+	}
+	key := deliveryKey{target: d.target.Name, backend: d.backend}
+	for _, alert := range d.notification.Alerts {
+		found := false
+		for _, fu := range n.followUps[alert.ID] {
+			if fu.key == key {
+				fu.state = alert.State
+				found = true
+			}
+		}
+		if !found {
+			n.followUps[alert.ID] = append(n.followUps[alert.ID], &followUp{key: key, state: alert.State})
+		}
+	}
+}
+
+// sendFollowUps tells everybody who has been notified about an alert once it has been
+// acknowledged or closed. Follow-ups are sent on a best-effort basis and are not retried.
+func (n *Notifier) sendFollowUps() {
+	for id, followUps := range n.followUps {
+		alert, err := n.store.GetAlert(id)
+		if err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				delete(n.followUps, id)
+			}
+			continue
+		}
+
+		var change *store.AlertStateChange
+		for _, fu := range followUps {
+			if fu.state == alert.State {
+				continue
+			}
+			fu.state = alert.State
+			if alert.State != store.StateAcknowledged && alert.State != store.StateClosed {
+				continue
+			}
+			if change == nil {
+				if history, err := n.store.GetAlertHistory(id); err == nil && len(history) > 0 {
+					change = &history[len(history)-1]
+				}
+			}
+
+			backend := n.backends[fu.key.backend].(NotifierBackendFollowUp)
+			ok, err := backend.FollowUp(n.ctx, n.targets[fu.key.target], alert, change)
+			if err != nil {
+				n.infoLog.Printf("notifier: failed to send follow-up about alert %s to '%s' via backend '%s': %v", id, fu.key.target, fu.key.backend, err)
+			} else if ok {
+				n.infoLog.Printf("notifier: sent follow-up about alert %s being %s to '%s' via backend '%s'", id, alert.State, fu.key.target, fu.key.backend)
+			}
+		}
+		if alert.State == store.StateClosed {
+			delete(n.followUps, id)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+//
+// Copyright (c) 2023 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.alerts nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package notifier
+
+import (
+	"time"
+
+	"github.com/whawty/alerts/store"
+)
+
+const (
+	heartbeatActor = "heartbeat-evaluator"
+)
+
+func (n *Notifier) evaluateHeartbeats() {
+	heartbeats, err := n.store.ListHeartbeats(-1, -1)
+	if err != nil {
+		n.infoLog.Printf("notifier: failed to list heartbeats: %v", err)
+		return
+	}
+
+	now := time.Now()
+	for _, heartbeat := range heartbeats {
+		missed := now.After(heartbeat.Deadline())
+		alert := heartbeat.Alert()
+		existing, err := n.store.GetAlertByFingerprint(alert.ComputeFingerprint())
+		if err != nil && err != store.ErrNotFound {
+			n.infoLog.Printf("notifier: failed to lookup alert for heartbeat '%s': %v", heartbeat.Name, err)
+			continue
+		}
+		active := existing != nil && existing.State != store.StateClosed
+
+		switch {
+		case missed && !active:
+			if alert, _, err = n.store.CreateAlert(alert); err != nil {
+				n.infoLog.Printf("notifier: failed to raise alert for heartbeat '%s': %v", heartbeat.Name, err)
+				continue
+			}
+			n.infoLog.Printf("notifier: heartbeat '%s' missed its deadline, raised alert %s", heartbeat.Name, alert.ID)
+		case !missed && active:
+			if _, err = n.store.SetAlertState(existing.ID, store.StateClosed, heartbeatActor, store.SourceAutomatic); err != nil {
+				n.infoLog.Printf("notifier: failed to close alert for heartbeat '%s': %v", heartbeat.Name, err)
+				continue
+			}
+			n.infoLog.Printf("notifier: heartbeat '%s' has been refreshed, closed alert %s", heartbeat.Name, existing.ID)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+//
+// Copyright (c) 2023 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.alerts nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package notifier
+
+import (
+	"github.com/whawty/alerts/store"
+)
+
+// inhibits reports whether the source alert suppresses notifications for the target alert.
+// Like in the Prometheus Alertmanager an alert never inhibits itself.
+func (r *InhibitRule) inhibits(source, target *store.Alert) bool {
+	if source.ID == target.ID {
+		return false
+	}
+	if !r.TargetMatchers.Matches(target) || !r.SourceMatchers.Matches(source) {
+		return false
+	}
+	for _, name := range r.Equal {
+		if source.LabelValue(name) != target.LabelValue(name) {
+			return false
+		}
+	}
+	return true
+}
+
+// inhibitedBy returns the first of the active alerts which inhibits the alert or nil if the
+// alert is not inhibited.
+func (n *Notifier) inhibitedBy(active []store.Alert, alert *store.Alert) *store.Alert {
+	for rIdx := range n.conf.InhibitRules {
+		for aIdx := range active {
+			if n.conf.InhibitRules[rIdx].inhibits(&active[aIdx], alert) {
+				return &active[aIdx]
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+//
+// Copyright (c) 2023 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.alerts nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package notifier
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/flosch/pongo2/v6"
+	"github.com/whawty/alerts/store"
+)
+
+// Notification is a group of alerts which is sent to a target as a single message. Suppressed
+// is the number of alerts which have not been sent to the target because of rate limits. A
+// notification might only report suppressed alerts and contain no alerts at all.
+type Notification struct {
+	Alerts      []*store.Alert
+	GroupLabels map[string]string
+	Suppressed  uint
+}
+
+type SeverityCount struct {
+	Severity store.AlertSeverity
+	Count    int
+}
+
+// SeverityCounts returns the number of alerts per severity, most severe first. Severities
+// without any alerts are omitted.
+func (n *Notification) SeverityCounts() (counts []SeverityCount) {
+	for _, severity := range []store.AlertSeverity{store.SeverityCritical, store.SeverityWarning, store.SeverityInformational} {
+		cnt := 0
+		for _, alert := range n.Alerts {
+			if alert.Severity == severity {
+				cnt++
+			}
+		}
+		if cnt > 0 {
+			counts = append(counts, SeverityCount{Severity: severity, Count: cnt})
+		}
+	}
+	return
+}
+
+// MaxSeverity returns the highest severity of all alerts.
+func (n *Notification) MaxSeverity() store.AlertSeverity {
+	severity := store.SeverityInformational
+	for _, alert := range n.Alerts {
+		if alert.Severity < severity {
+			severity = alert.Severity
+		}
+	}
+	return severity
+}
+
+// First returns at most count alerts from the start of the notification.
+func (n *Notification) First(count int) []*store.Alert {
+	if count < 0 || count > len(n.Alerts) {
+		count = len(n.Alerts)
+	}
+	return n.Alerts[:count]
+}
+
+// Remaining returns the number of alerts which are not included in First(count).
+func (n *Notification) Remaining(count int) int {
+	return len(n.Alerts) - len(n.First(count))
+}
+
+func (n *Notification) String() string {
+	if len(n.Alerts) == 0 {
+		return fmt.Sprintf("%d suppressed alert(s)", n.Suppressed)
+	}
+	if len(n.Alerts) == 1 {
+		return "alert " + n.Alerts[0].ID
+	}
+	ids := make([]string, 0, len(n.Alerts))
+	for _, alert := range n.Alerts {
+		ids = append(ids, alert.ID)
+	}
+	return fmt.Sprintf("%d alerts (%s)", len(n.Alerts), strings.Join(ids, ", "))
+}
+
+// notificationPayload is the JSON representation of a notification sent to a target.
+type notificationPayload struct {
+	Target      string            `json:"target"`
+	Alerts      []*store.Alert    `json:"alerts"`
+	GroupLabels map[string]string `json:"groupLabels,omitempty"`
+	Suppressed  uint              `json:"suppressed,omitempty"`
+}
+
+func (n *Notification) payload(target NotifierTarget) notificationPayload {
+	return notificationPayload{Target: target.Name, Alerts: n.Alerts, GroupLabels: n.GroupLabels, Suppressed: n.Suppressed}
+}
+
+// templateContext returns the variables available to notification templates. For
+// compatibility with templates written for single alerts, alert refers to the first alert.
+func (n *Notification) templateContext() pongo2.Context {
+	ctx := pongo2.Context{"alerts": n.Alerts, "notification": n}
+	if len(n.Alerts) > 0 {
+		ctx["alert"] = n.Alerts[0]
+	}
+	return ctx
+}
@@ -35,6 +35,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/whawty/alerts/store"
@@ -47,11 +48,23 @@ type Notifier struct {
 	dbgLog   *log.Logger
 	ctx      context.Context
 	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+	targets  map[string]NotifierTarget
 	backends map[string]NotifierBackend
+	policies map[string]*EscalationPolicy
+	commands *commandHandler
+
+	dueSince  map[string]time.Time
+	pending   map[string]map[deliveryKey]*deliveryState
+	followUps map[string][]*followUp
+
+	rateLimits map[string]*RateLimit
+	fallbacks  map[string]string
 }
 
 func (n *Notifier) Close() error {
 	n.cancel()
+	n.wg.Wait()
 	for _, backend := range n.backends {
 		if backend.Ready() {
 			backend.Close()
@@ -60,7 +73,33 @@ func (n *Notifier) Close() error {
 	return nil
 }
 
+func (n *Notifier) run() {
+	defer n.wg.Done()
+
+	ticker := time.NewTicker(n.conf.Interval)
+	defer ticker.Stop()
+	for {
+		n.evaluateHeartbeats()
+		n.dispatch()
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
 func NewNotifier(conf *Config, st *store.Store, infoLog, dbgLog *log.Logger) (n *Notifier, err error) {
+	if n, err = newNotifier(conf, st, infoLog, dbgLog); err != nil {
+		return
+	}
+	n.start()
+	return
+}
+
+// newNotifier creates a notifier from conf without initializing any backends or starting
+// the dispatch loop.
+func newNotifier(conf *Config, st *store.Store, infoLog, dbgLog *log.Logger) (n *Notifier, err error) {
 	if infoLog == nil {
 		infoLog = log.New(io.Discard, "", 0)
 	}
@@ -68,13 +107,59 @@ func NewNotifier(conf *Config, st *store.Store, infoLog, dbgLog *log.Logger) (n
 		dbgLog = log.New(io.Discard, "", 0)
 	}
 
-	n = &Notifier{conf: conf, store: st, infoLog: infoLog, dbgLog: dbgLog}
+	n = &Notifier{conf: conf, store: st, infoLog: infoLog, dbgLog: dbgLog, dueSince: make(map[string]time.Time)}
+	n.pending = make(map[string]map[deliveryKey]*deliveryState)
+	n.followUps = make(map[string][]*followUp)
 	n.ctx, n.cancel = context.WithCancel(context.Background())
 	if n.conf.Interval <= 0 {
 		n.conf.Interval = 1 * time.Minute
 	}
+	if n.conf.BackendRetry.MinBackoff <= 0 {
+		n.conf.BackendRetry.MinBackoff = 10 * time.Second
+	}
+	if n.conf.BackendRetry.MaxBackoff <= 0 {
+		n.conf.BackendRetry.MaxBackoff = 10 * time.Minute
+	}
+	if n.conf.BackendRetry.MaxBackoff < n.conf.BackendRetry.MinBackoff {
+		n.conf.BackendRetry.MaxBackoff = n.conf.BackendRetry.MinBackoff
+	}
+	if n.conf.DeliveryRetry.MaxAttempts == 0 {
+		n.conf.DeliveryRetry.MaxAttempts = 5
+	}
+	if n.conf.DeliveryRetry.MinBackoff <= 0 {
+		n.conf.DeliveryRetry.MinBackoff = 1 * time.Minute
+	}
+	if n.conf.DeliveryRetry.MaxBackoff <= 0 {
+		n.conf.DeliveryRetry.MaxBackoff = 30 * time.Minute
+	}
+	if n.conf.DeliveryRetry.MaxBackoff < n.conf.DeliveryRetry.MinBackoff {
+		n.conf.DeliveryRetry.MaxBackoff = n.conf.DeliveryRetry.MinBackoff
+	}
 
+	n.targets = make(map[string]NotifierTarget)
+	n.rateLimits = make(map[string]*RateLimit)
+	for idx, target := range n.conf.Targets {
+		if target.Name == "" {
+			err = fmt.Errorf("found unnamed target at config index %d", idx)
+			return
+		}
+		if _, exists := n.targets[target.Name]; exists {
+			err = fmt.Errorf("found duplicate target name at config index %d", idx)
+			return
+		}
+		n.targets[target.Name] = target
+		if target.RateLimit != nil {
+			if err = target.RateLimit.check(); err != nil {
+				err = fmt.Errorf("target '%s' has invalid rate limit: %v", target.Name, err)
+				return
+			}
+			n.rateLimits["target:"+target.Name] = target.RateLimit
+		}
+	}
+
+	n.commands = newCommandHandler(st, n.conf.Targets, infoLog)
 	n.backends = make(map[string]NotifierBackend)
+	n.fallbacks = make(map[string]string)
 	for idx, backend := range n.conf.Backends {
 		if backend.Name == "" {
 			err = fmt.Errorf("found unnamed backend at config index %d", idx)
@@ -92,7 +177,39 @@ func NewNotifier(conf *Config, st *store.Store, infoLog, dbgLog *log.Logger) (n
 			cnt = cnt + 1
 		}
 		if backend.SMSModem != nil {
-			b = NewSMSModemBackend(backend.Name, backend.SMSModem, infoLog, dbgLog)
+			b = NewSMSModemBackend(backend.Name, backend.SMSModem, n.commands, infoLog, dbgLog)
+			cnt = cnt + 1
+		}
+		if backend.Webhook != nil {
+			b = NewWebhookBackend(backend.Name, backend.Webhook, infoLog, dbgLog)
+			cnt = cnt + 1
+		}
+		if backend.Matrix != nil {
+			b = NewMatrixBackend(backend.Name, backend.Matrix, n.commands, infoLog, dbgLog)
+			cnt = cnt + 1
+		}
+		if backend.Ntfy != nil {
+			b = NewNtfyBackend(backend.Name, backend.Ntfy, infoLog, dbgLog)
+			cnt = cnt + 1
+		}
+		if backend.Gotify != nil {
+			b = NewGotifyBackend(backend.Name, backend.Gotify, infoLog, dbgLog)
+			cnt = cnt + 1
+		}
+		if backend.Telegram != nil {
+			b = NewTelegramBackend(backend.Name, backend.Telegram, n.commands, infoLog, dbgLog)
+			cnt = cnt + 1
+		}
+		if backend.Slack != nil {
+			b = NewSlackBackend(backend.Name, backend.Slack, infoLog, dbgLog)
+			cnt = cnt + 1
+		}
+		if backend.Exec != nil {
+			b = NewExecBackend(backend.Name, backend.Exec, infoLog, dbgLog)
+			cnt = cnt + 1
+		}
+		if backend.XMPP != nil {
+			b = NewXMPPBackend(backend.Name, backend.XMPP, n.commands, infoLog, dbgLog)
 			cnt = cnt + 1
 		}
 		if cnt == 0 {
@@ -104,34 +221,50 @@ func NewNotifier(conf *Config, st *store.Store, infoLog, dbgLog *log.Logger) (n
 			return
 		}
 		n.backends[backend.Name] = b
-
-		if err := b.Init(); err != nil {
-			infoLog.Printf("notifier: failed to initialize backend '%s': %v", backend.Name, err)
-		} else {
-			infoLog.Printf("notifier: backend '%s' successfully initialized", backend.Name)
+		if backend.RateLimit != nil {
+			if err = backend.RateLimit.check(); err != nil {
+				err = fmt.Errorf("backend '%s' has invalid rate limit: %v", backend.Name, err)
+				return
+			}
+			n.rateLimits["backend:"+backend.Name] = backend.RateLimit
+		}
+		if backend.Fallback != "" {
+			n.fallbacks[backend.Name] = backend.Fallback
 		}
 	}
-
-	// TODO: start go-routine to re-initialize failed backends
-	// TODO: start go-routine to handle notfications
-
-	a := &store.Alert{}
-	a.State = store.StateClosed
-	a.Severity = store.SeverityCritical
-	a.Name = "This is just a drill!"
-	for _, t := range n.conf.Targets {
-		for bName, b := range n.backends {
-			sent, err := b.Notify(context.TODO(), t, a)
-			if err != nil {
-				infoLog.Printf("notifier: failed to notify '%s' via backend '%s': %v", t.Name, bName, err)
-				continue
-			}
-			if sent {
-				infoLog.Printf("notifier: sent notification to '%s' via backend '%s'", t.Name, bName)
-			}
+	for name, fallback := range n.fallbacks {
+		if _, exists := n.backends[fallback]; !exists || fallback == name {
+			err = fmt.Errorf("backend '%s' has invalid fallback backend '%s'", name, fallback)
+			return
 		}
 	}
 
-	infoLog.Printf("notifier: started with %d backends and evaluation interval %s", len(n.backends), conf.Interval.String())
+	if err = n.checkEscalationPolicies(); err != nil {
+		return
+	}
+	if n.conf.Route == nil {
+		if n.conf.Route, err = n.defaultRoute(); err != nil {
+			return
+		}
+	}
+	if len(n.conf.Route.Matchers) > 0 {
+		err = fmt.Errorf("the root route must not have any matchers")
+		return
+	}
+	err = n.checkRoute(n.conf.Route, "route")
 	return
 }
+
+func (n *Notifier) start() {
+	states := make(map[string]*backendSupervisorState)
+	for name := range n.backends {
+		states[name] = &backendSupervisorState{}
+		n.initBackend(name, states[name])
+	}
+
+	n.wg.Add(2)
+	go n.runSupervisor(states)
+	go n.run()
+
+	n.infoLog.Printf("notifier: started with %d backends and evaluation interval %s", len(n.backends), n.conf.Interval.String())
+}
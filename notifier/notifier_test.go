@@ -0,0 +1,122 @@
+//
+// Copyright (c) 2023 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.alerts nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package notifier
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/whawty/alerts/store"
+	"gopkg.in/yaml.v3"
+)
+
+var testLog = log.New(io.Discard, "", 0)
+
+func newTestStore(t *testing.T) *store.Store {
+	t.Helper()
+	st, err := store.Open(&store.Config{Path: filepath.Join(t.TempDir(), "test.db")}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	t.Cleanup(func() { st.Close() })
+	return st
+}
+
+// waitFor polls condition until it is true or the timeout has expired.
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if condition() {
+			return
+		}
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+type testNotification struct {
+	target     string
+	alerts     int
+	suppressed uint
+}
+
+// testBackend records all notifications and can reach every target.
+type testBackend struct {
+	sent []testNotification
+}
+
+func (b *testBackend) Init() error {
+	return nil
+}
+
+func (b *testBackend) Ready() bool {
+	return true
+}
+
+func (b *testBackend) Notify(ctx context.Context, target NotifierTarget, notification *Notification) (bool, error) {
+	b.sent = append(b.sent, testNotification{target: target.Name, alerts: len(notification.Alerts), suppressed: notification.Suppressed})
+	return true, nil
+}
+
+func (b *testBackend) Close() error {
+	return nil
+}
+
+// newTestNotifier creates a notifier which uses backends but doesn't run the dispatch loop.
+func newTestNotifier(t *testing.T, targets []NotifierTarget, backends map[string]NotifierBackend) *Notifier {
+	t.Helper()
+	n, err := newNotifier(&Config{Targets: targets}, newTestStore(t), nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create notifier: %v", err)
+	}
+	n.backends = backends
+	return n
+}
+
+func TestSampleConfig(t *testing.T) {
+	data, err := os.ReadFile("../contrib/sample-cfg.yml")
+	if err != nil {
+		t.Fatalf("failed to read sample config: %v", err)
+	}
+	var conf struct {
+		Notifier Config `yaml:"notifier"`
+	}
+	if err = yaml.Unmarshal(data, &conf); err != nil {
+		t.Fatalf("failed to parse sample config: %v", err)
+	}
+	if _, err = newNotifier(&conf.Notifier, newTestStore(t), nil, nil); err != nil {
+		t.Fatalf("sample config is invalid: %v", err)
+	}
+}
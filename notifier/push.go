@@ -0,0 +1,97 @@
+//
+// Copyright (c) 2023 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.alerts nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package notifier
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/flosch/pongo2/v6"
+	"github.com/whawty/alerts/store"
+)
+
+// Helpers for backends which send short push notifications consisting of a title and a message.
+
+const (
+	defaultPushTitleTemplate = `{% autoescape off %}{% if alerts|length == 1 %}{{ alert.Severity.Emoji() }} [{{ alert.Severity }}] {{ alert.Name }}{% elif alerts %}{{ alerts|length }} alerts{% for c in notification.SeverityCounts() %} | {{ c.Severity.Emoji() }} {{ c.Count }} {{ c.Severity }}{% endfor %}{% else %}{{ notification.Suppressed }} alerts suppressed by rate limit{% endif %}{% endautoescape %}`
+	defaultPushTemplate      = `{% autoescape off %}{% for alert in alerts %}{{ alert.State.Emoji() }} {{ alert.Name }} ({{ alert.ShortID() }}){% if alert.Description %}: {{ alert.Description }}{% endif %}
+{% endfor %}{% if notification.Suppressed %}{{ notification.Suppressed }} more alerts suppressed by rate limit{% endif %}{% endautoescape %}`
+)
+
+type pushTemplates struct {
+	title   *pongo2.Template
+	message *pongo2.Template
+}
+
+func newPushTemplates(title, message string) (t *pushTemplates, err error) {
+	if title == "" {
+		title = defaultPushTitleTemplate
+	}
+	if message == "" {
+		message = defaultPushTemplate
+	}
+	t = &pushTemplates{}
+	if t.title, err = pongo2.FromString(title); err != nil {
+		return nil, fmt.Errorf("failed to parse title template: %v", err)
+	}
+	if t.message, err = pongo2.FromString(message); err != nil {
+		return nil, fmt.Errorf("failed to parse template: %v", err)
+	}
+	return
+}
+
+func (t *pushTemplates) render(notification *Notification) (title, message string, err error) {
+	ctx := notification.templateContext()
+	if title, err = t.title.Execute(ctx); err != nil {
+		return
+	}
+	if message, err = t.message.Execute(ctx); err != nil {
+		return
+	}
+	return strings.TrimSpace(title), strings.TrimSpace(message), nil
+}
+
+// severityPriorities merges the configured priorities, which are indexed by severity names,
+// into the defaults.
+func severityPriorities(conf map[string]int, defaults map[store.AlertSeverity]int) (map[store.AlertSeverity]int, error) {
+	priorities := make(map[store.AlertSeverity]int)
+	for severity, priority := range defaults {
+		priorities[severity] = priority
+	}
+	for name, priority := range conf {
+		var severity store.AlertSeverity
+		if err := severity.FromString(name); err != nil {
+			return nil, err
+		}
+		priorities[severity] = priority
+	}
+	return priorities, nil
+}
@@ -0,0 +1,221 @@
+//
+// Copyright (c) 2023 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.alerts nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/whawty/alerts/store"
+)
+
+type testPushRequest struct {
+	path   string
+	header http.Header
+	body   map[string]interface{}
+}
+
+// testPushServer records all requests and answers them with the configured status code.
+type testPushServer struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	status   int
+	requests []testPushRequest
+}
+
+func newTestPushServer(t *testing.T) *testPushServer {
+	s := &testPushServer{status: http.StatusOK}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := testPushRequest{path: r.URL.Path, header: r.Header}
+		data, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(data, &req.body); err != nil || r.Method != http.MethodPost {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		s.requests = append(s.requests, req)
+		w.WriteHeader(s.status)
+		w.Write([]byte("{}"))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *testPushServer) respondWith(status int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.status = status
+}
+
+func (s *testPushServer) last(t *testing.T) testPushRequest {
+	t.Helper()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(s.requests) == 0 {
+		t.Fatalf("server did not receive any request")
+	}
+	return s.requests[len(s.requests)-1]
+}
+
+func testPushNotification() *Notification {
+	return &Notification{Alerts: []*store.Alert{
+		{ID: "01HAAAAAAAAAAAAAAAAAAAAAAA", Name: "disk full", Severity: store.SeverityWarning, State: store.StateOpen},
+		{ID: "01HBBBBBBBBBBBBBBBBBBBBBBB", Name: "host down", Severity: store.SeverityCritical, State: store.StateOpen},
+		{ID: "01HCCCCCCCCCCCCCCCCCCCCCCC", Name: "load high", Severity: store.SeverityInformational, State: store.StateAcknowledged},
+	}}
+}
+
+func TestNtfyBackend(t *testing.T) {
+	server := newTestPushServer(t)
+	topic := NotifierTargetNtfy("alerts")
+	target := NotifierTarget{Name: "ops", Ntfy: &topic}
+
+	if err := NewNtfyBackend("test", &NotifierBackendConfigNtfy{Server: server.URL, Priorities: map[string]int{"critical": 6}}, testLog, testLog).Init(); err == nil {
+		t.Fatalf("priorities above 5 must be rejected")
+	}
+
+	nb := NewNtfyBackend("test", &NotifierBackendConfigNtfy{Server: server.URL + "/", Token: "tk_secret", Timeout: 5 * time.Second}, testLog, testLog)
+	if err := nb.Init(); err != nil {
+		t.Fatalf("failed to initialize backend: %v", err)
+	}
+	defer nb.Close()
+	if sent, err := nb.Notify(context.Background(), NotifierTarget{Name: "email-only"}, testPushNotification()); sent || err != nil {
+		t.Fatalf("targets without ntfy topic must be skipped, got %t, %v", sent, err)
+	}
+	if sent, err := nb.Notify(context.Background(), target, testPushNotification()); err != nil || !sent {
+		t.Fatalf("failed to send notification: %v", err)
+	}
+	req := server.last(t)
+	if req.path != "/" {
+		t.Errorf("unexpected path: %s", req.path)
+	}
+	if auth := req.header.Get("Authorization"); auth != "Bearer tk_secret" {
+		t.Errorf("unexpected authorization header: %s", auth)
+	}
+	if req.body["topic"] != "alerts" || req.body["priority"] != float64(5) {
+		t.Errorf("unexpected topic or priority: %v", req.body)
+	}
+	if title, _ := req.body["title"].(string); !strings.HasPrefix(title, "3 alerts") {
+		t.Errorf("unexpected title: %s", title)
+	}
+	if message, _ := req.body["message"].(string); !strings.Contains(message, "host down") || !strings.Contains(message, "load high") {
+		t.Errorf("unexpected message: %s", message)
+	}
+	tags, _ := req.body["tags"].([]interface{})
+	if len(tags) != 2 || tags[0] != store.StateOpen.Emoji().String() || tags[1] != store.StateAcknowledged.Emoji().String() {
+		t.Errorf("expected one tag per alert state, got %v", tags)
+	}
+
+	// the priority is configurable per severity, basic auth is used if there is no token
+	nb = NewNtfyBackend("test", &NotifierBackendConfigNtfy{Server: server.URL, Username: "hugo", Password: "secret", Priorities: map[string]int{"warning": 2}}, testLog, testLog)
+	if err := nb.Init(); err != nil {
+		t.Fatalf("failed to initialize backend: %v", err)
+	}
+	defer nb.Close()
+	notification := &Notification{Alerts: testPushNotification().Alerts[:1]}
+	if _, err := nb.Notify(context.Background(), target, notification); err != nil {
+		t.Fatalf("failed to send notification: %v", err)
+	}
+	req = server.last(t)
+	if username, password, ok := (&http.Request{Header: req.header}).BasicAuth(); !ok || username != "hugo" || password != "secret" {
+		t.Errorf("expected basic auth credentials, got %s", req.header.Get("Authorization"))
+	}
+	if req.body["priority"] != float64(2) {
+		t.Errorf("expected configured priority, got %v", req.body["priority"])
+	}
+	if title, _ := req.body["title"].(string); !strings.HasSuffix(title, "[warning] disk full") {
+		t.Errorf("unexpected title: %s", title)
+	}
+
+	server.respondWith(http.StatusForbidden)
+	if _, err := nb.Notify(context.Background(), target, notification); err == nil {
+		t.Fatalf("errors returned by the server must be reported")
+	}
+}
+
+func TestGotifyBackend(t *testing.T) {
+	server := newTestPushServer(t)
+	token := NotifierTargetGotify("AbCdEf")
+	target := NotifierTarget{Name: "ops", Gotify: &token}
+
+	if err := NewGotifyBackend("test", &NotifierBackendConfigGotify{}, testLog, testLog).Init(); err == nil {
+		t.Fatalf("backends without server must be rejected")
+	}
+	if err := NewGotifyBackend("test", &NotifierBackendConfigGotify{Server: server.URL, Priorities: map[string]int{"warning": 11}}, testLog, testLog).Init(); err == nil {
+		t.Fatalf("priorities above 10 must be rejected")
+	}
+
+	gb := NewGotifyBackend("test", &NotifierBackendConfigGotify{Server: server.URL + "/", Priorities: map[string]int{"informational": 0}}, testLog, testLog)
+	if err := gb.Init(); err != nil {
+		t.Fatalf("failed to initialize backend: %v", err)
+	}
+	defer gb.Close()
+	if sent, err := gb.Notify(context.Background(), NotifierTarget{Name: "email-only"}, testPushNotification()); sent || err != nil {
+		t.Fatalf("targets without Gotify token must be skipped, got %t, %v", sent, err)
+	}
+	if sent, err := gb.Notify(context.Background(), target, testPushNotification()); err != nil || !sent {
+		t.Fatalf("failed to send notification: %v", err)
+	}
+	req := server.last(t)
+	if req.path != "/message" {
+		t.Errorf("unexpected path: %s", req.path)
+	}
+	if key := req.header.Get("X-Gotify-Key"); key != "AbCdEf" {
+		t.Errorf("unexpected application token: %s", key)
+	}
+	if req.body["priority"] != float64(8) {
+		t.Errorf("expected priority of critical alerts, got %v", req.body["priority"])
+	}
+	if message, _ := req.body["message"].(string); !strings.Contains(message, "disk full") {
+		t.Errorf("unexpected message: %s", message)
+	}
+
+	notification := &Notification{Alerts: testPushNotification().Alerts[2:]}
+	if _, err := gb.Notify(context.Background(), target, notification); err != nil {
+		t.Fatalf("failed to send notification: %v", err)
+	}
+	if req = server.last(t); req.body["priority"] != float64(0) {
+		t.Errorf("expected configured priority, got %v", req.body["priority"])
+	}
+
+	server.respondWith(http.StatusUnauthorized)
+	if _, err := gb.Notify(context.Background(), target, notification); err == nil {
+		t.Fatalf("errors returned by the server must be reported")
+	}
+}
@@ -0,0 +1,157 @@
+//
+// Copyright (c) 2023 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.alerts nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package notifier
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/whawty/alerts/store"
+)
+
+func (rl *RateLimit) check() error {
+	if rl.Limit == 0 {
+		return fmt.Errorf("limit must be at least 1")
+	}
+	if rl.Interval <= 0 {
+		return fmt.Errorf("interval must be positive")
+	}
+	return nil
+}
+
+// rateLimiter is a token bucket which holds up to limit tokens and gets refilled by limit
+// tokens per interval. Every notification which is sent consumes one token.
+type rateLimiter struct {
+	key   string
+	conf  *RateLimit
+	state *store.RateLimit
+}
+
+func (l *rateLimiter) refill(now time.Time) {
+	if elapsed := now.Sub(l.state.UpdatedAt); elapsed > 0 {
+		rate := float64(l.conf.Limit) / float64(l.conf.Interval)
+		l.state.Tokens = math.Min(float64(l.conf.Limit), l.state.Tokens+rate*float64(elapsed))
+	}
+	l.state.UpdatedAt = now
+}
+
+func (l *rateLimiter) available() bool {
+	return l.state.Tokens >= 1
+}
+
+// suppress adds alerts to the number of alerts which need to be reported to target via backend.
+func (l *rateLimiter) suppress(target, backend string, alerts uint) {
+	for idx := range l.state.Suppressed {
+		if s := &l.state.Suppressed[idx]; s.Target == target && s.Backend == backend {
+			s.Alerts += alerts
+			return
+		}
+	}
+	l.state.Suppressed = append(l.state.Suppressed, store.RateLimitSuppressed{Target: target, Backend: backend, Alerts: alerts})
+}
+
+func (l *rateLimiter) suppressed(target, backend string) uint {
+	for _, s := range l.state.Suppressed {
+		if s.Target == target && s.Backend == backend {
+			return s.Alerts
+		}
+	}
+	return 0
+}
+
+// reported forgets about the alerts suppressed for target via backend once they have been
+// reported.
+func (l *rateLimiter) reported(target, backend string) {
+	for idx, s := range l.state.Suppressed {
+		if s.Target == target && s.Backend == backend {
+			l.state.Suppressed = append(l.state.Suppressed[:idx], l.state.Suppressed[idx+1:]...)
+			return
+		}
+	}
+}
+
+// rateLimiters returns the limiters which apply to notifications sent to target via backend.
+// The state of the limiters is loaded from the store and refilled up to now.
+func (n *Notifier) rateLimiters(target, backend string, now time.Time) (limiters []*rateLimiter) {
+	for _, key := range []string{"target:" + target, "backend:" + backend} {
+		conf, exists := n.rateLimits[key]
+		if !exists {
+			continue
+		}
+		l := &rateLimiter{key: key, conf: conf}
+		state, err := n.store.GetRateLimit(key)
+		if err != nil {
+			if !errors.Is(err, store.ErrNotFound) {
+				n.infoLog.Printf("notifier: failed to load state of rate limit '%s', starting over: %v", l.key, err)
+			}
+			state = &store.RateLimit{Tokens: float64(l.conf.Limit), UpdatedAt: now}
+		}
+		l.state = state
+		l.refill(now)
+		limiters = append(limiters, l)
+	}
+	return
+}
+
+func (n *Notifier) saveRateLimiters(limiters []*rateLimiter) {
+	for _, l := range limiters {
+		if err := n.store.SaveRateLimit(l.key, l.state); err != nil {
+			n.infoLog.Printf("notifier: failed to save state of rate limit '%s': %v", l.key, err)
+		}
+	}
+}
+
+// flushSuppressed reports alerts which have been suppressed by rate limits as soon as the
+// limits allow it and no other notification has already done so. Every target only gets told
+// about the alerts which have been suppressed for itself.
+func (n *Notifier) flushSuppressed(now time.Time) {
+	for key := range n.rateLimits {
+		state, err := n.store.GetRateLimit(key)
+		if err != nil {
+			continue
+		}
+		for _, suppressed := range state.Suppressed {
+			target, exists := n.targets[suppressed.Target]
+			if _, ok := n.backends[suppressed.Backend]; !exists || !ok {
+				continue
+			}
+			available := true
+			for _, l := range n.rateLimiters(target.Name, suppressed.Backend, now) {
+				available = available && l.available()
+			}
+			if available {
+				n.deliver(&delivery{target: target, backend: suppressed.Backend, notification: &Notification{}}, now)
+			}
+		}
+	}
+}
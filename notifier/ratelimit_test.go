@@ -0,0 +1,124 @@
+//
+// Copyright (c) 2023 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.alerts nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package notifier
+
+import (
+	"testing"
+	"time"
+
+	"github.com/whawty/alerts/store"
+)
+
+func TestRateLimiterRefill(t *testing.T) {
+	now := time.Now()
+	l := &rateLimiter{key: "test", conf: &RateLimit{Limit: 4, Interval: 4 * time.Second}, state: &store.RateLimit{UpdatedAt: now}}
+	if l.available() {
+		t.Fatalf("empty bucket must not have tokens available")
+	}
+
+	testVectors := []struct {
+		elapsed time.Duration
+		tokens  float64
+	}{
+		{500 * time.Millisecond, 0.5},
+		{time.Second, 1.5},
+		// time going backwards must not change anything
+		{-time.Second, 1.5},
+		{2 * time.Second, 3.5},
+		{time.Hour, 4},
+	}
+	for _, vector := range testVectors {
+		now = now.Add(vector.elapsed)
+		l.refill(now)
+		if l.state.Tokens != vector.tokens {
+			t.Fatalf("after %s: expected %g tokens, got %g", vector.elapsed, vector.tokens, l.state.Tokens)
+		}
+		if !l.state.UpdatedAt.Equal(now) {
+			t.Fatalf("refill must update the timestamp")
+		}
+	}
+	if !l.available() {
+		t.Fatalf("full bucket must have tokens available")
+	}
+}
+
+func TestRateLimitSummarizePerTarget(t *testing.T) {
+	backend := &testBackend{}
+	targets := []NotifierTarget{{Name: "alice"}, {Name: "bob"}}
+	n := newTestNotifier(t, targets, map[string]NotifierBackend{"test": backend})
+	n.rateLimits["backend:test"] = &RateLimit{Limit: 1, Interval: time.Hour, Overflow: RateLimitSummarize}
+
+	alert := &store.Alert{ID: "01HAAAAAAAAAAAAAAAAAAAAAAA", Name: "test"}
+	now := time.Now()
+	deliver := func(target string, alerts int) deliveryResult {
+		notification := &Notification{}
+		for i := 0; i < alerts; i++ {
+			notification.Alerts = append(notification.Alerts, alert)
+		}
+		result, err := n.deliver(&delivery{target: n.targets[target], backend: "test", notification: notification}, now)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return result
+	}
+
+	if result := deliver("alice", 1); result != deliverySent {
+		t.Fatalf("first notification must be sent, got %d", result)
+	}
+	if result := deliver("bob", 1); result != deliverySuppressed {
+		t.Fatalf("second notification must be suppressed, got %d", result)
+	}
+	if result := deliver("alice", 2); result != deliverySuppressed {
+		t.Fatalf("third notification must be suppressed, got %d", result)
+	}
+
+	// every refill allows for one summary, every target only gets told about its own alerts
+	now = now.Add(time.Hour)
+	n.flushSuppressed(now)
+	now = now.Add(time.Hour)
+	n.flushSuppressed(now)
+	now = now.Add(time.Hour)
+	n.flushSuppressed(now)
+
+	expected := []testNotification{
+		{target: "alice", alerts: 1},
+		{target: "bob", suppressed: 1},
+		{target: "alice", suppressed: 2},
+	}
+	if len(backend.sent) != len(expected) {
+		t.Fatalf("expected %d notifications, got %+v", len(expected), backend.sent)
+	}
+	for idx := range expected {
+		if backend.sent[idx] != expected[idx] {
+			t.Errorf("notification %d: expected %+v, got %+v", idx, expected[idx], backend.sent[idx])
+		}
+	}
+}
@@ -0,0 +1,130 @@
+//
+// Copyright (c) 2023 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.alerts nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package notifier
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/whawty/alerts/store"
+)
+
+type routeResult struct {
+	targets  []string
+	backends []string
+	policy   string
+}
+
+func (r *Route) match(alert *store.Alert, parent routeResult) []routeResult {
+	if !r.Matchers.Matches(alert) {
+		return nil
+	}
+	current := parent
+	if len(r.Targets) > 0 || r.EscalationPolicy != "" {
+		current = routeResult{targets: r.Targets, backends: r.Backends, policy: r.EscalationPolicy}
+	} else if len(r.Backends) > 0 {
+		current.backends = r.Backends
+	}
+
+	var results []routeResult
+	for idx := range r.Routes {
+		child := &r.Routes[idx]
+		result := child.match(alert, current)
+		if result == nil {
+			continue
+		}
+		results = append(results, result...)
+		if !child.Continue {
+			break
+		}
+	}
+	if len(results) == 0 {
+		results = []routeResult{current}
+	}
+	return results
+}
+
+// route returns the results of all routes matching the alert. The root route defaults to
+// all targets via all backends.
+func (n *Notifier) route(alert *store.Alert) []routeResult {
+	root := routeResult{}
+	for _, target := range n.conf.Targets {
+		root.targets = append(root.targets, target.Name)
+	}
+	return n.conf.Route.match(alert, root)
+}
+
+func (n *Notifier) checkRoute(r *Route, path string) error {
+	if err := n.checkReceivers(r.Targets, r.Backends); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	if r.EscalationPolicy != "" {
+		if _, exists := n.policies[r.EscalationPolicy]; !exists {
+			return fmt.Errorf("%s: unknown escalation policy '%s'", path, r.EscalationPolicy)
+		}
+	}
+	for idx := range r.Routes {
+		if err := n.checkRoute(&r.Routes[idx], fmt.Sprintf("%s.routes[%d]", path, idx)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// defaultRoute is used if no route is configured. It sends alerts to the first escalation
+// policy whose severities and labels match the alert and to all targets otherwise.
+func (n *Notifier) defaultRoute() (*Route, error) {
+	root := &Route{}
+	for _, policy := range n.conf.EscalationPolicies {
+		r := Route{EscalationPolicy: policy.Name}
+		if len(policy.Severities) > 0 {
+			var severities []string
+			for _, severity := range policy.Severities {
+				severities = append(severities, regexp.QuoteMeta(severity.String()))
+			}
+			m, err := store.NewMatcher("severity", store.MatchRegexp, strings.Join(severities, "|"))
+			if err != nil {
+				return nil, err
+			}
+			r.Matchers = append(r.Matchers, *m)
+		}
+		for name, value := range policy.Labels {
+			m, err := store.NewMatcher(name, store.MatchEqual, value)
+			if err != nil {
+				return nil, err
+			}
+			r.Matchers = append(r.Matchers, *m)
+		}
+		root.Routes = append(root.Routes, r)
+	}
+	return root, nil
+}
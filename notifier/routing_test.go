@@ -0,0 +1,137 @@
+//
+// Copyright (c) 2023 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.alerts nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package notifier
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/whawty/alerts/store"
+)
+
+func testMatchers(t *testing.T, strs ...string) (ms store.Matchers) {
+	t.Helper()
+	for _, str := range strs {
+		var m store.Matcher
+		if err := m.FromString(str); err != nil {
+			t.Fatalf("invalid matcher '%s': %v", str, err)
+		}
+		ms = append(ms, m)
+	}
+	return
+}
+
+func TestRouteMatch(t *testing.T) {
+	root := Route{
+		Routes: []Route{
+			{
+				Matchers: testMatchers(t, `team=db`),
+				Targets:  []string{"db-oncall"},
+				Continue: true,
+				Routes: []Route{
+					{Matchers: testMatchers(t, `severity=critical`), Backends: []string{"sms"}},
+					{Matchers: testMatchers(t, `severity=~"critical|warning"`), Backends: []string{"email"}},
+				},
+			},
+			{Matchers: testMatchers(t, `instance=~"db.*"`), EscalationPolicy: "databases"},
+			{Matchers: testMatchers(t, `team=~"db|web"`), Targets: []string{"ops"}, Backends: []string{"matrix"}},
+			{Targets: []string{"catch-all"}},
+		},
+	}
+	parent := routeResult{targets: []string{"everybody"}}
+
+	testVectors := []struct {
+		labels   map[string]string
+		severity store.AlertSeverity
+		expected []routeResult
+	}{
+		// the first child which matches stops the evaluation of its siblings unless continue is set
+		{map[string]string{"team": "db"}, store.SeverityCritical, []routeResult{
+			{targets: []string{"db-oncall"}, backends: []string{"sms"}},
+			{targets: []string{"ops"}, backends: []string{"matrix"}},
+		}},
+		{map[string]string{"team": "db"}, store.SeverityWarning, []routeResult{
+			{targets: []string{"db-oncall"}, backends: []string{"email"}},
+			{targets: []string{"ops"}, backends: []string{"matrix"}},
+		}},
+		// a route without matching children uses its own targets
+		{map[string]string{"team": "db", "instance": "db1"}, store.SeverityInformational, []routeResult{
+			{targets: []string{"db-oncall"}},
+			{policy: "databases"},
+		}},
+		{map[string]string{"team": "web"}, store.SeverityCritical, []routeResult{
+			{targets: []string{"ops"}, backends: []string{"matrix"}},
+		}},
+		{map[string]string{"team": "dbx"}, store.SeverityCritical, []routeResult{
+			{targets: []string{"catch-all"}},
+		}},
+	}
+	for _, vector := range testVectors {
+		alert := &store.Alert{Name: "test", Severity: vector.severity, Labels: vector.labels}
+		results := root.match(alert, parent)
+		if !reflect.DeepEqual(results, vector.expected) {
+			t.Errorf("%v/%s: expected %+v, got %+v", vector.labels, vector.severity, vector.expected, results)
+		}
+	}
+}
+
+func TestRouteMatchInheritance(t *testing.T) {
+	root := Route{
+		Targets: []string{"ops"},
+		Routes: []Route{
+			{Matchers: testMatchers(t, `severity=critical`), Backends: []string{"sms"}},
+		},
+	}
+	parent := routeResult{targets: []string{"everybody"}}
+
+	alert := &store.Alert{Name: "test", Severity: store.SeverityCritical}
+	expected := []routeResult{{targets: []string{"ops"}, backends: []string{"sms"}}}
+	if results := root.match(alert, parent); !reflect.DeepEqual(results, expected) {
+		t.Errorf("routes with backends only must inherit the targets: expected %+v, got %+v", expected, results)
+	}
+
+	alert.Severity = store.SeverityWarning
+	expected = []routeResult{{targets: []string{"ops"}}}
+	if results := root.match(alert, parent); !reflect.DeepEqual(results, expected) {
+		t.Errorf("expected %+v, got %+v", expected, results)
+	}
+
+	root.Matchers = testMatchers(t, `team=db`)
+	if results := root.match(alert, parent); results != nil {
+		t.Errorf("routes which don't match must not return results, got %+v", results)
+	}
+	root.Matchers = nil
+	root.Targets = nil
+	expected = []routeResult{{targets: []string{"everybody"}}}
+	if results := root.match(alert, parent); !reflect.DeepEqual(results, expected) {
+		t.Errorf("routes without targets must inherit the parent: expected %+v, got %+v", expected, results)
+	}
+}
@@ -0,0 +1,93 @@
+//
+// Copyright (c) 2023 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.alerts nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package notifier
+
+import (
+	"time"
+)
+
+const (
+	supervisorCheckInterval = time.Second
+)
+
+type backendSupervisorState struct {
+	backoff time.Duration
+	next    time.Time
+}
+
+func (n *Notifier) initBackend(name string, state *backendSupervisorState) {
+	err := n.backends[name].Init()
+	if err == nil {
+		n.infoLog.Printf("notifier: backend '%s' successfully initialized", name)
+		state.backoff = 0
+		return
+	}
+
+	if state.backoff <= 0 {
+		state.backoff = n.conf.BackendRetry.MinBackoff
+	} else {
+		state.backoff = state.backoff * 2
+	}
+	if state.backoff > n.conf.BackendRetry.MaxBackoff {
+		state.backoff = n.conf.BackendRetry.MaxBackoff
+	}
+	state.next = time.Now().Add(state.backoff)
+	n.infoLog.Printf("notifier: failed to initialize backend '%s': %v (retrying in %s)", name, err, state.backoff)
+}
+
+// runSupervisor re-initializes all backends which are not ready. This covers backends
+// which failed to initialize at startup as well as backends which failed at runtime.
+func (n *Notifier) runSupervisor(states map[string]*backendSupervisorState) {
+	defer n.wg.Done()
+
+	ticker := time.NewTicker(supervisorCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		for name, backend := range n.backends {
+			if backend.Ready() {
+				continue
+			}
+			state := states[name]
+			if now.Before(state.next) {
+				continue
+			}
+			n.dbgLog.Printf("notifier: backend '%s' is not ready, trying to re-initialize it", name)
+			n.initBackend(name, state)
+		}
+	}
+}
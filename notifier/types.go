@@ -32,51 +32,379 @@ package notifier
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"github.com/whawty/alerts/store"
 )
 
+type EMailTLSMode uint
+
+const (
+	EMailTLSAuto EMailTLSMode = iota
+	EMailTLSNone
+	EMailTLSStartTLS
+	EMailTLSImplicit
+)
+
+func (m EMailTLSMode) String() string {
+	switch m {
+	case EMailTLSAuto:
+		return "auto"
+	case EMailTLSNone:
+		return "none"
+	case EMailTLSStartTLS:
+		return "starttls"
+	case EMailTLSImplicit:
+		return "tls"
+	}
+	return "unknown"
+}
+
+func (m *EMailTLSMode) FromString(str string) error {
+	switch str {
+	case "auto":
+		*m = EMailTLSAuto
+	case "none":
+		*m = EMailTLSNone
+	case "starttls":
+		*m = EMailTLSStartTLS
+	case "tls":
+		*m = EMailTLSImplicit
+	default:
+		return errors.New("invalid e-mail TLS mode: '" + str + "'")
+	}
+	return nil
+}
+
+func (m EMailTLSMode) MarshalText() (data []byte, err error) {
+	data = []byte(m.String())
+	return
+}
+
+func (m *EMailTLSMode) UnmarshalText(data []byte) (err error) {
+	return m.FromString(string(data))
+}
+
+type RateLimitOverflow uint
+
+const (
+	RateLimitSummarize RateLimitOverflow = iota
+	RateLimitDefer
+	RateLimitDrop
+)
+
+func (o RateLimitOverflow) String() string {
+	switch o {
+	case RateLimitSummarize:
+		return "summarize"
+	case RateLimitDefer:
+		return "defer"
+	case RateLimitDrop:
+		return "drop"
+	}
+	return "unknown"
+}
+
+func (o *RateLimitOverflow) FromString(str string) error {
+	switch str {
+	case "summarize":
+		*o = RateLimitSummarize
+	case "defer":
+		*o = RateLimitDefer
+	case "drop":
+		*o = RateLimitDrop
+	default:
+		return errors.New("invalid rate limit overflow: '" + str + "'")
+	}
+	return nil
+}
+
+func (o RateLimitOverflow) MarshalText() (data []byte, err error) {
+	data = []byte(o.String())
+	return
+}
+
+func (o *RateLimitOverflow) UnmarshalText(data []byte) (err error) {
+	return o.FromString(string(data))
+}
+
+// RateLimit allows at most limit notifications per interval. Notifications exceeding the limit
+// are either dropped, deferred until the limit allows them to be sent or summarized by telling
+// the receiver how many alerts have been suppressed once the limit allows it.
+type RateLimit struct {
+	Limit    uint              `yaml:"limit"`
+	Interval time.Duration     `yaml:"interval"`
+	Overflow RateLimitOverflow `yaml:"overflow"`
+}
+
+type NotifierBackendConfigEMailAuth struct {
+	Mechanism string `yaml:"mechanism"`
+	Username  string `yaml:"username"`
+	Password  string `yaml:"password"`
+}
+
 type NotifierBackendConfigEMail struct {
-	From      string `yaml:"from"`
-	Smarthost string `yaml:"smarthost"`
-	// TODO: add auth and TLS support
+	From            string                          `yaml:"from"`
+	Smarthost       string                          `yaml:"smarthost"`
+	Hello           string                          `yaml:"hello"`
+	Timeout         time.Duration                   `yaml:"timeout"`
+	TLSMode         EMailTLSMode                    `yaml:"tlsMode"`
+	TLS             *TLSClientConfig                `yaml:"tls"`
+	Auth            *NotifierBackendConfigEMailAuth `yaml:"auth"`
+	SubjectTemplate string                          `yaml:"subjectTemplate"`
+	Template        string                          `yaml:"template"`
 }
 
 type NotifierBackendConfigSMSModem struct {
-	Device   string        `yaml:"device"`
-	Baudrate int           `yaml:"baudrate"`
-	Timeout  time.Duration `yaml:"timeout"`
-	Pin      *uint         `yaml:"pin"`
-	Template string        `yaml:"template"`
+	Device          string        `yaml:"device"`
+	Baudrate        int           `yaml:"baudrate"`
+	Timeout         time.Duration `yaml:"timeout"`
+	Pin             *uint         `yaml:"pin"`
+	Template        string        `yaml:"template"`
+	SummaryTemplate string        `yaml:"summaryTemplate"`
+	SummarySize     int           `yaml:"summarySize"`
+}
+
+type NotifierBackendConfigWebhookHMAC struct {
+	Secret string `yaml:"secret"`
+	Header string `yaml:"header"`
 }
 
+// NotifierBackendConfigWebhook configures a backend which POSTs notifications to the URL of the
+// target. Without a template the notification is sent as JSON. Requests which fail because of
+// network or server errors are retried up to retries times. Timeout limits the time spent on a
+// notification including all retries, after that the delivery retries of the notifier apply.
+type NotifierBackendConfigWebhook struct {
+	Timeout     time.Duration                     `yaml:"timeout"`
+	TLS         *TLSClientConfig                  `yaml:"tls"`
+	Headers     map[string]string                 `yaml:"headers"`
+	HMAC        *NotifierBackendConfigWebhookHMAC `yaml:"hmac"`
+	Template    string                            `yaml:"template"`
+	ContentType string                            `yaml:"contentType"`
+	Retries     uint                              `yaml:"retries"`
+	RetryDelay  time.Duration                     `yaml:"retryDelay"`
+}
+
+// NotifierBackendConfigMatrix configures a backend which sends notifications to the Matrix room
+// of the target. If commands is set, messages starting with "!" and reactions to notifications
+// are handled as commands.
+type NotifierBackendConfigMatrix struct {
+	Homeserver   string           `yaml:"homeserver"`
+	AccessToken  string           `yaml:"accessToken"`
+	Timeout      time.Duration    `yaml:"timeout"`
+	TLS          *TLSClientConfig `yaml:"tls"`
+	Template     string           `yaml:"template"`
+	HTMLTemplate string           `yaml:"htmlTemplate"`
+	Commands     bool             `yaml:"commands"`
+}
+
+// NotifierBackendConfigNtfy configures a backend which publishes notifications to the ntfy topic of
+// the target. Priorities map severities to ntfy message priorities (1-5).
+type NotifierBackendConfigNtfy struct {
+	Server        string           `yaml:"server"`
+	Token         string           `yaml:"token"`
+	Username      string           `yaml:"username"`
+	Password      string           `yaml:"password"`
+	Timeout       time.Duration    `yaml:"timeout"`
+	TLS           *TLSClientConfig `yaml:"tls"`
+	Priorities    map[string]int   `yaml:"priorities"`
+	TitleTemplate string           `yaml:"titleTemplate"`
+	Template      string           `yaml:"template"`
+}
+
+// NotifierBackendConfigGotify configures a backend which sends notifications to a Gotify server
+// using the application token of the target. Priorities map severities to Gotify message
+// priorities (0-10).
+type NotifierBackendConfigGotify struct {
+	Server        string           `yaml:"server"`
+	Timeout       time.Duration    `yaml:"timeout"`
+	TLS           *TLSClientConfig `yaml:"tls"`
+	Priorities    map[string]int   `yaml:"priorities"`
+	TitleTemplate string           `yaml:"titleTemplate"`
+	Template      string           `yaml:"template"`
+}
+
+// NotifierBackendConfigTelegram configures a bot which sends notifications to the chat of the
+// target. The template is rendered as HTML.
+type NotifierBackendConfigTelegram struct {
+	APIURL   string           `yaml:"apiURL"`
+	Token    string           `yaml:"token"`
+	Timeout  time.Duration    `yaml:"timeout"`
+	TLS      *TLSClientConfig `yaml:"tls"`
+	Template string           `yaml:"template"`
+}
+
+// NotifierBackendConfigSlack configures a backend which posts notifications to Slack compatible
+// incoming webhooks, i.e. Slack or Mattermost. Messages link back to the web interface at uiURL,
+// e.g. https://alerts.example.com/admin/.
+type NotifierBackendConfigSlack struct {
+	Timeout   time.Duration    `yaml:"timeout"`
+	TLS       *TLSClientConfig `yaml:"tls"`
+	Username  string           `yaml:"username"`
+	IconEmoji string           `yaml:"iconEmoji"`
+	IconURL   string           `yaml:"iconURL"`
+	UIURL     string           `yaml:"uiURL"`
+}
+
+// NotifierBackendConfigExec configures a backend which runs command for every notification. The
+// notification is passed as JSON on stdin and the most severe alert is also described by
+// WHAWTY_ALERT_* environment variables. Notifications are considered sent if the command exits
+// with status 0 within timeout. At most concurrency commands are run at the same time.
+type NotifierBackendConfigExec struct {
+	Command     []string          `yaml:"command"`
+	Environment map[string]string `yaml:"environment"`
+	Timeout     time.Duration     `yaml:"timeout"`
+	Concurrency uint              `yaml:"concurrency"`
+}
+
+// NotifierBackendConfigXMPP configures a client which sends notifications as chat messages to
+// the JID of the target. Targets whose JID is one of the multi-user chat rooms the client joins
+// get group chat messages. Replies like "ack <id>" from targets are handled as commands.
+type NotifierBackendConfigXMPP struct {
+	JID      string           `yaml:"jid"`
+	Password string           `yaml:"password"`
+	Server   string           `yaml:"server"`
+	Timeout  time.Duration    `yaml:"timeout"`
+	TLS      *TLSClientConfig `yaml:"tls"`
+	Rooms    []string         `yaml:"rooms"`
+	Nick     string           `yaml:"nick"`
+	Template string           `yaml:"template"`
+}
+
+// NotifierBackendConfig configures a backend. Once all attempts to send a notification via
+// the backend have failed the notification will be sent to the same target via the fallback
+// backend if one is configured.
 type NotifierBackendConfig struct {
-	Name     string
-	EMail    *NotifierBackendConfigEMail    `yaml:"email"`
-	SMSModem *NotifierBackendConfigSMSModem `yaml:"smsModem"`
+	Name      string
+	RateLimit *RateLimit                     `yaml:"rateLimit"`
+	Fallback  string                         `yaml:"fallback"`
+	EMail     *NotifierBackendConfigEMail    `yaml:"email"`
+	SMSModem  *NotifierBackendConfigSMSModem `yaml:"smsModem"`
+	Webhook   *NotifierBackendConfigWebhook  `yaml:"webhook"`
+	Matrix    *NotifierBackendConfigMatrix   `yaml:"matrix"`
+	Ntfy      *NotifierBackendConfigNtfy     `yaml:"ntfy"`
+	Gotify    *NotifierBackendConfigGotify   `yaml:"gotify"`
+	Telegram  *NotifierBackendConfigTelegram `yaml:"telegram"`
+	Slack     *NotifierBackendConfigSlack    `yaml:"slack"`
+	Exec      *NotifierBackendConfigExec     `yaml:"exec"`
+	XMPP      *NotifierBackendConfigXMPP     `yaml:"xmpp"`
 }
 
 type NotifierTargetSMS string
 type NotifierTargetEMail string
+type NotifierTargetWebhook string
+type NotifierTargetMatrix string
+type NotifierTargetNtfy string
+type NotifierTargetGotify string
+type NotifierTargetTelegram string
+type NotifierTargetSlack string
+type NotifierTargetExec string
+type NotifierTargetXMPP string
 
 type NotifierTarget struct {
-	Name  string               `yaml:"name"`
-	EMail *NotifierTargetEMail `yaml:"email"`
-	SMS   *NotifierTargetSMS   `yaml:"sms"`
+	Name      string                  `yaml:"name"`
+	EMail     *NotifierTargetEMail    `yaml:"email"`
+	SMS       *NotifierTargetSMS      `yaml:"sms"`
+	Webhook   *NotifierTargetWebhook  `yaml:"webhook"`
+	Matrix    *NotifierTargetMatrix   `yaml:"matrix"`
+	Ntfy      *NotifierTargetNtfy     `yaml:"ntfy"`
+	Gotify    *NotifierTargetGotify   `yaml:"gotify"`
+	Telegram  *NotifierTargetTelegram `yaml:"telegram"`
+	Slack     *NotifierTargetSlack    `yaml:"slack"`
+	Exec      *NotifierTargetExec     `yaml:"exec"`
+	XMPP      *NotifierTargetXMPP     `yaml:"xmpp"`
+	RateLimit *RateLimit              `yaml:"rateLimit"`
+}
+
+type BackendRetryConfig struct {
+	MinBackoff time.Duration `yaml:"minBackoff"`
+	MaxBackoff time.Duration `yaml:"maxBackoff"`
+}
+
+// DeliveryRetryConfig controls how often failed notifications are retried. The delay between
+// attempts starts at minBackoff and doubles after every attempt up to maxBackoff.
+type DeliveryRetryConfig struct {
+	MaxAttempts uint          `yaml:"maxAttempts"`
+	MinBackoff  time.Duration `yaml:"minBackoff"`
+	MaxBackoff  time.Duration `yaml:"maxBackoff"`
+}
+
+type EscalationStep struct {
+	Delay    time.Duration `yaml:"delay"`
+	Targets  []string      `yaml:"targets"`
+	Backends []string      `yaml:"backends"`
+}
+
+// EscalationPolicy defines steps of receivers which get notified once the delay of the
+// step has passed since the alert has been opened. If no route is configured the first
+// policy whose severities and labels match an alert will be used for it.
+type EscalationPolicy struct {
+	Name       string                `yaml:"name"`
+	Severities []store.AlertSeverity `yaml:"severities"`
+	Labels     map[string]string     `yaml:"labels"`
+	Steps      []EscalationStep      `yaml:"steps"`
+}
+
+// Route decides who gets notified about alerts matching all of its matchers. Routes
+// without targets and escalation policy inherit them from their parent. If an alert matches
+// a sub-route the route itself is not used. The search for matching sub-routes stops at the
+// first match unless this sub-route has continue set.
+type Route struct {
+	Matchers         store.Matchers `yaml:"matchers"`
+	Targets          []string       `yaml:"targets"`
+	Backends         []string       `yaml:"backends"`
+	EscalationPolicy string         `yaml:"escalationPolicy"`
+	Continue         bool           `yaml:"continue"`
+	Routes           []Route        `yaml:"routes"`
+}
+
+// InhibitRule suppresses notifications for alerts matching the target matchers as long as there
+// is an active alert matching the source matchers which has the same values for all labels
+// listed in equal.
+type InhibitRule struct {
+	SourceMatchers store.Matchers `yaml:"sourceMatchers"`
+	TargetMatchers store.Matchers `yaml:"targetMatchers"`
+	Equal          []string       `yaml:"equal"`
+}
+
+// GroupConfig controls how alerts are batched into notifications. Alerts which have the same
+// values for all labels listed in by are sent to a target as one notification once wait has
+// passed since the first of them became due.
+type GroupConfig struct {
+	By   []string      `yaml:"by"`
+	Wait time.Duration `yaml:"wait"`
 }
 
 type Config struct {
-	Interval time.Duration           `yaml:"interval"`
-	Backends []NotifierBackendConfig `yaml:"backends"`
-	Targets  []NotifierTarget        `yaml:"targets"`
+	Interval           time.Duration           `yaml:"interval"`
+	RenotifyInterval   time.Duration           `yaml:"renotifyInterval"`
+	BackendRetry       BackendRetryConfig      `yaml:"backendRetry"`
+	DeliveryRetry      DeliveryRetryConfig     `yaml:"deliveryRetry"`
+	Group              GroupConfig             `yaml:"group"`
+	Backends           []NotifierBackendConfig `yaml:"backends"`
+	Targets            []NotifierTarget        `yaml:"targets"`
+	EscalationPolicies []EscalationPolicy      `yaml:"escalationPolicies"`
+	Route              *Route                  `yaml:"route"`
+	InhibitRules       []InhibitRule           `yaml:"inhibitRules"`
 }
 
 // Interfaces
 
+// errBackendNotReady is returned by NotifierBackend.Notify if the target could be reached via
+// the backend but the backend is not ready. Notify returns false without an error only if the
+// target can't be reached via the backend at all.
+var errBackendNotReady = errors.New("backend is not ready")
+
 type NotifierBackend interface {
 	Init() error
 	Ready() bool
-	Notify(context.Context, NotifierTarget, *store.Alert) (bool, error)
+	Notify(context.Context, NotifierTarget, *Notification) (bool, error)
 	Close() error
 }
+
+// NotifierBackendFollowUp is implemented by backends which post follow-up messages once alerts
+// they have sent notifications about get acknowledged or closed.
+type NotifierBackendFollowUp interface {
+	FollowUp(context.Context, NotifierTarget, *store.Alert, *store.AlertStateChange) (bool, error)
+}
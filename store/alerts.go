@@ -30,27 +30,337 @@
 
 package store
 
-func (s *Store) CreateAlert(alert *Alert) (*Alert, error) {
-	// TODO: implement this
-	return nil, ErrNotImplemented
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	deduplicationActor = "deduplication"
+	notifierActor      = "notifier"
+)
+
+// CreateAlert stores a new alert unless there already is an alert with the same fingerprint.
+// In this case the existing alert is updated instead and created will be false. Closed alerts
+// are either re-opened or superseded by a new alert depending on the store configuration.
+func (s *Store) CreateAlert(alert *Alert) (result *Alert, created bool, err error) {
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		now := time.Now()
+		alert.Fingerprint = alert.ComputeFingerprint()
+
+		fps := tx.Bucket(bucketFingerprints)
+		if id := fps.Get([]byte(alert.Fingerprint)); id != nil {
+			existing := &Alert{}
+			if err := getJSON(tx.Bucket(bucketAlerts), string(id), existing); err != nil {
+				return err
+			}
+			if existing.State != StateClosed || s.conf.ReopenClosed {
+				existing.Occurrences++
+				existing.UpdatedAt = now
+				result = existing
+				if existing.State == StateClosed || existing.State == StateStale {
+					return setAlertState(tx, existing, StateOpen, deduplicationActor, SourceAutomatic)
+				}
+				return putJSON(tx.Bucket(bucketAlerts), existing.ID, existing)
+			}
+		}
+
+		alert.ID = ulid.Make().String()
+		alert.CreatedAt = now
+		alert.UpdatedAt = now
+		alert.ActivatedAt = now
+		alert.Occurrences = 1
+		alert.NotifiedAt = nil
+		alert.Escalation = nil
+		if err := putJSON(tx.Bucket(bucketAlerts), alert.ID, alert); err != nil {
+			return err
+		}
+		result = alert
+		created = true
+		return fps.Put([]byte(alert.Fingerprint), []byte(alert.ID))
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return
+}
+
+func (s *Store) ListAlerts(offset, limit int) (alerts []Alert, err error) {
+	offset, limit = normalizePagination(offset, limit)
+	alerts = []Alert{}
+	err = s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucketAlerts).Cursor()
+		idx := 0
+		for k, v := c.First(); k != nil && len(alerts) < limit; k, v = c.Next() {
+			if idx < offset {
+				idx++
+				continue
+			}
+			var alert Alert
+			if err := json.Unmarshal(v, &alert); err != nil {
+				return err
+			}
+			alerts = append(alerts, alert)
+		}
+		return nil
+	})
+	return
+}
+
+func (s *Store) ListAlertsByState(states ...AlertState) (alerts []Alert, err error) {
+	alerts = []Alert{}
+	err = s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketAlerts).ForEach(func(k, v []byte) error {
+			var alert Alert
+			if err := json.Unmarshal(v, &alert); err != nil {
+				return err
+			}
+			for _, state := range states {
+				if alert.State == state {
+					alerts = append(alerts, alert)
+					break
+				}
+			}
+			return nil
+		})
+	})
+	return
+}
+
+func (s *Store) GetAlert(id string) (alert *Alert, err error) {
+	alert = &Alert{}
+	err = s.db.View(func(tx *bolt.Tx) error {
+		return getJSON(tx.Bucket(bucketAlerts), id, alert)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return
 }
 
-func (s *Store) ListAlerts(offset, limit int) ([]Alert, error) {
-	// TODO: implement this
-	return nil, ErrNotImplemented
+func (s *Store) GetAlertByFingerprint(fingerprint string) (alert *Alert, err error) {
+	alert = &Alert{}
+	err = s.db.View(func(tx *bolt.Tx) error {
+		id := tx.Bucket(bucketFingerprints).Get([]byte(fingerprint))
+		if id == nil {
+			return ErrNotFound
+		}
+		return getJSON(tx.Bucket(bucketAlerts), string(id), alert)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return
 }
 
-func (s *Store) GetAlert(id string) (*Alert, error) {
-	// TODO: implement this
-	return nil, ErrNotImplemented
+// GetAlertByShortID returns the alert whose ID ends with id. At least ShortIDLength characters
+// are required and ids which match more than one alert are rejected.
+func (s *Store) GetAlertByShortID(id string) (alert *Alert, err error) {
+	suffix := []byte(strings.ToUpper(id))
+	if len(suffix) < ShortIDLength {
+		return nil, ErrShortIDTooShort
+	}
+	alert = &Alert{}
+	err = s.db.View(func(tx *bolt.Tx) error {
+		var match []byte
+		c := tx.Bucket(bucketAlerts).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if !bytes.HasSuffix(k, suffix) {
+				continue
+			}
+			if match != nil {
+				return ErrAmbiguousID
+			}
+			match = v
+		}
+		if match == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(match, alert)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return
 }
 
-func (s *Store) SetAlertState(id string, new AlertState) (*Alert, error) {
-	// TODO: implement this
-	return nil, ErrNotImplemented
+func (s *Store) SetAlertState(id string, new AlertState, actor string, source StateChangeSource) (alert *Alert, err error) {
+	alert = &Alert{}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketAlerts)
+		if err := getJSON(b, id, alert); err != nil {
+			return err
+		}
+		if alert.State == new {
+			return nil
+		}
+		return setAlertState(tx, alert, new, actor, source)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// MarkAlertNotified records that notifications for the alert have been sent as well as the
+// current escalation state of the alert, i.e. the number of steps which have been notified
+// per escalation policy. New alerts will be moved to StateOpen.
+func (s *Store) MarkAlertNotified(id string, escalation map[string]int) (alert *Alert, err error) {
+	alert = &Alert{}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketAlerts)
+		if err := getJSON(b, id, alert); err != nil {
+			return err
+		}
+		now := time.Now()
+		alert.NotifiedAt = &now
+		alert.Escalation = nil
+		if len(escalation) > 0 {
+			alert.Escalation = escalation
+		}
+		if alert.State == StateNew {
+			return setAlertState(tx, alert, StateOpen, notifierActor, SourceAutomatic)
+		}
+		return putJSON(b, id, alert)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return
 }
 
 func (s *Store) DeleteAlert(id string) error {
-	// TODO: implement this
-	return ErrNotImplemented
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketAlerts)
+		alert := &Alert{}
+		if err := getJSON(b, id, alert); err != nil {
+			return err
+		}
+		if err := b.Delete([]byte(id)); err != nil {
+			return err
+		}
+		fps := tx.Bucket(bucketFingerprints)
+		if string(fps.Get([]byte(alert.Fingerprint))) == id {
+			if err := fps.Delete([]byte(alert.Fingerprint)); err != nil {
+				return err
+			}
+		}
+		if err := tx.Bucket(bucketAlertHistory).DeleteBucket([]byte(id)); err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+		if err := tx.Bucket(bucketDeliveries).DeleteBucket([]byte(id)); err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+		return nil
+	})
+}
+
+func (s *Store) GetAlertHistory(id string) (history []AlertStateChange, err error) {
+	history = []AlertStateChange{}
+	err = s.db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(bucketAlerts).Get([]byte(id)) == nil {
+			return ErrNotFound
+		}
+		b := tx.Bucket(bucketAlertHistory).Bucket([]byte(id))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var change AlertStateChange
+			if err := json.Unmarshal(v, &change); err != nil {
+				return err
+			}
+			history = append(history, change)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+func (s *Store) AddAlertDelivery(delivery *Delivery) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(bucketAlerts).Get([]byte(delivery.Alert)) == nil {
+			return ErrNotFound
+		}
+		b, err := tx.Bucket(bucketDeliveries).CreateBucketIfNotExists([]byte(delivery.Alert))
+		if err != nil {
+			return err
+		}
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(delivery)
+		if err != nil {
+			return err
+		}
+		return b.Put(sequenceKey(seq), data)
+	})
+}
+
+func (s *Store) GetAlertDeliveries(id string) (deliveries []Delivery, err error) {
+	deliveries = []Delivery{}
+	err = s.db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(bucketAlerts).Get([]byte(id)) == nil {
+			return ErrNotFound
+		}
+		b := tx.Bucket(bucketDeliveries).Bucket([]byte(id))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var delivery Delivery
+			if err := json.Unmarshal(v, &delivery); err != nil {
+				return err
+			}
+			deliveries = append(deliveries, delivery)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// setAlertState must be called from within a read-write transaction. It validates the transition,
+// updates the alert and appends the change to the history of the alert.
+func setAlertState(tx *bolt.Tx, alert *Alert, new AlertState, actor string, source StateChangeSource) error {
+	if !alert.State.CanTransitionTo(new) {
+		return ErrInvalidStateTransition{old: alert.State, new: new}
+	}
+	change := AlertStateChange{Timestamp: time.Now(), Old: alert.State, New: new, Actor: actor, Source: source}
+	if new == StateOpen && alert.State != StateNew {
+		// alerts which got (re-)opened need to be notified and escalated again
+		alert.ActivatedAt = change.Timestamp
+		alert.NotifiedAt = nil
+		alert.Escalation = nil
+	}
+	alert.State = new
+	alert.UpdatedAt = change.Timestamp
+	if err := putJSON(tx.Bucket(bucketAlerts), alert.ID, alert); err != nil {
+		return err
+	}
+
+	b, err := tx.Bucket(bucketAlertHistory).CreateBucketIfNotExists([]byte(alert.ID))
+	if err != nil {
+		return err
+	}
+	seq, err := b.NextSequence()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+	return b.Put(sequenceKey(seq), data)
 }
@@ -0,0 +1,117 @@
+//
+// Copyright (c) 2023 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.alerts nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package store
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func openTestStore(t *testing.T) *Store {
+	t.Helper()
+	s, err := Open(&Config{Path: filepath.Join(t.TempDir(), "test.db")}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func putTestAlerts(t *testing.T, s *Store, ids ...string) {
+	t.Helper()
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		for _, id := range ids {
+			if err := putJSON(tx.Bucket(bucketAlerts), id, &Alert{ID: id, Name: id}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to store alerts: %v", err)
+	}
+}
+
+func TestGetAlertByShortID(t *testing.T) {
+	s := openTestStore(t)
+	putTestAlerts(t, s, "01HAAAAAAAAAAAAAAAAAABCDEF", "01HBBBBBBBBBBBBBBBBBBBCDEF", "01HCCCCCCCCCCCCCCCCCXYZ123")
+
+	testVectors := []struct {
+		id       string
+		expected string
+		err      error
+	}{
+		{"xyz123", "01HCCCCCCCCCCCCCCCCCXYZ123", nil},
+		{"XYZ123", "01HCCCCCCCCCCCCCCCCCXYZ123", nil},
+		{"cxyz123", "01HCCCCCCCCCCCCCCCCCXYZ123", nil},
+		{"01HCCCCCCCCCCCCCCCCCXYZ123", "01HCCCCCCCCCCCCCCCCCXYZ123", nil},
+		{"3", "", ErrShortIDTooShort},
+		{"z123", "", ErrShortIDTooShort},
+		{"", "", ErrShortIDTooShort},
+		{"bcdef", "", ErrShortIDTooShort},
+		{"abcdef", "01HAAAAAAAAAAAAAAAAAABCDEF", nil},
+		{"bbcdef", "01HBBBBBBBBBBBBBBBBBBBCDEF", nil},
+		{"BBCDEF", "01HBBBBBBBBBBBBBBBBBBBCDEF", nil},
+		{"000000", "", ErrNotFound},
+	}
+	for _, vector := range testVectors {
+		alert, err := s.GetAlertByShortID(vector.id)
+		if err != vector.err {
+			t.Fatalf("'%s': expected error %v, got %v", vector.id, vector.err, err)
+		}
+		if err == nil && alert.ID != vector.expected {
+			t.Fatalf("'%s': expected alert %s, got %s", vector.id, vector.expected, alert.ID)
+		}
+	}
+
+	putTestAlerts(t, s, "01HDDDDDDDDDDDDDDDDDABCDEF")
+	if _, err := s.GetAlertByShortID("abcdef"); err != ErrAmbiguousID {
+		t.Fatalf("expected ambiguous ID to be rejected, got %v", err)
+	}
+}
+
+func TestCreateAlertResetsNotificationState(t *testing.T) {
+	s := openTestStore(t)
+	notified := time.Now()
+	alert, created, err := s.CreateAlert(&Alert{Name: "test", NotifiedAt: &notified, Escalation: map[string]int{"hugo": 2}})
+	if err != nil || !created {
+		t.Fatalf("failed to create alert: %v", err)
+	}
+	if alert, err = s.GetAlert(alert.ID); err != nil {
+		t.Fatalf("failed to get alert: %v", err)
+	}
+	if alert.NotifiedAt != nil || alert.Escalation != nil {
+		t.Fatalf("new alerts must not have been notified, got %v, %v", alert.NotifiedAt, alert.Escalation)
+	}
+}
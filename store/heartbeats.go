@@ -30,27 +30,85 @@
 
 package store
 
-func (s *Store) CreateHeartbeat(alert *Heartbeat) (*Heartbeat, error) {
-	// TODO: implement this
-	return nil, ErrNotImplemented
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+	bolt "go.etcd.io/bbolt"
+)
+
+func (s *Store) CreateHeartbeat(heartbeat *Heartbeat) (*Heartbeat, error) {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		heartbeat.ID = ulid.Make().String()
+		heartbeat.CreatedAt = time.Now()
+		heartbeat.UpdatedAt = heartbeat.CreatedAt
+		heartbeat.RefreshedAt = nil
+		return putJSON(tx.Bucket(bucketHeartbeats), heartbeat.ID, heartbeat)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return heartbeat, nil
 }
 
-func (s *Store) ListHeartbeats(offset, limit int) ([]Heartbeat, error) {
-	// TODO: implement this
-	return nil, ErrNotImplemented
+func (s *Store) ListHeartbeats(offset, limit int) (heartbeats []Heartbeat, err error) {
+	offset, limit = normalizePagination(offset, limit)
+	heartbeats = []Heartbeat{}
+	err = s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucketHeartbeats).Cursor()
+		idx := 0
+		for k, v := c.First(); k != nil && len(heartbeats) < limit; k, v = c.Next() {
+			if idx < offset {
+				idx++
+				continue
+			}
+			var heartbeat Heartbeat
+			if err := json.Unmarshal(v, &heartbeat); err != nil {
+				return err
+			}
+			heartbeats = append(heartbeats, heartbeat)
+		}
+		return nil
+	})
+	return
 }
 
-func (s *Store) GetHeartbeat(id string) (*Heartbeat, error) {
-	// TODO: implement this
-	return nil, ErrNotImplemented
+func (s *Store) GetHeartbeat(id string) (heartbeat *Heartbeat, err error) {
+	heartbeat = &Heartbeat{}
+	err = s.db.View(func(tx *bolt.Tx) error {
+		return getJSON(tx.Bucket(bucketHeartbeats), id, heartbeat)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return
 }
 
-func (s *Store) RefreshHeartbeat(id string) (*Heartbeat, error) {
-	// TODO: implement this
-	return nil, ErrNotImplemented
+func (s *Store) RefreshHeartbeat(id string) (heartbeat *Heartbeat, err error) {
+	heartbeat = &Heartbeat{}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketHeartbeats)
+		if err := getJSON(b, id, heartbeat); err != nil {
+			return err
+		}
+		now := time.Now()
+		heartbeat.RefreshedAt = &now
+		heartbeat.UpdatedAt = now
+		return putJSON(b, id, heartbeat)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return
 }
 
 func (s *Store) DeleteHeartbeat(id string) error {
-	// TODO: implement this
-	return ErrNotImplemented
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketHeartbeats)
+		if b.Get([]byte(id)) == nil {
+			return ErrNotFound
+		}
+		return b.Delete([]byte(id))
+	})
 }
@@ -0,0 +1,165 @@
+//
+// Copyright (c) 2023 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.alerts nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package store
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type MatchType uint
+
+const (
+	MatchEqual MatchType = iota
+	MatchNotEqual
+	MatchRegexp
+	MatchNotRegexp
+)
+
+func (t MatchType) String() string {
+	switch t {
+	case MatchEqual:
+		return "="
+	case MatchNotEqual:
+		return "!="
+	case MatchRegexp:
+		return "=~"
+	case MatchNotRegexp:
+		return "!~"
+	}
+	return "unknown"
+}
+
+func (t *MatchType) FromString(str string) error {
+	switch str {
+	case "=":
+		*t = MatchEqual
+	case "!=":
+		*t = MatchNotEqual
+	case "=~":
+		*t = MatchRegexp
+	case "!~":
+		*t = MatchNotRegexp
+	default:
+		return errors.New("invalid match type: '" + str + "'")
+	}
+	return nil
+}
+
+var (
+	matcherRe = regexp.MustCompile(`^\s*([a-zA-Z_][a-zA-Z0-9_.-]*)\s*(=~|!~|!=|=)\s*(.*?)\s*$`)
+)
+
+// Matcher matches a label of an alert against a value. Matchers are written as
+// <name><type><value>, e.g.: team="db", instance=~"db[0-9]+" or severity!=informational.
+// Regular expressions must match the whole label value.
+type Matcher struct {
+	Name  string
+	Type  MatchType
+	Value string
+	re    *regexp.Regexp
+}
+
+func NewMatcher(name string, t MatchType, value string) (m *Matcher, err error) {
+	m = &Matcher{Name: name, Type: t, Value: value}
+	if t == MatchRegexp || t == MatchNotRegexp {
+		if m.re, err = regexp.Compile("^(?:" + value + ")$"); err != nil {
+			return nil, err
+		}
+	}
+	return
+}
+
+func (m Matcher) String() string {
+	return m.Name + m.Type.String() + strconv.Quote(m.Value)
+}
+
+func (m *Matcher) FromString(str string) error {
+	parts := matcherRe.FindStringSubmatch(str)
+	if parts == nil {
+		return errors.New("invalid matcher: '" + str + "'")
+	}
+	var t MatchType
+	if err := t.FromString(parts[2]); err != nil {
+		return err
+	}
+	value := parts[3]
+	if len(value) >= 2 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`) {
+		var err error
+		if value, err = strconv.Unquote(value); err != nil {
+			return errors.New("invalid matcher value: " + err.Error())
+		}
+	}
+	matcher, err := NewMatcher(parts[1], t, value)
+	if err != nil {
+		return err
+	}
+	*m = *matcher
+	return nil
+}
+
+func (m Matcher) MarshalText() (data []byte, err error) {
+	data = []byte(m.String())
+	return
+}
+
+func (m *Matcher) UnmarshalText(data []byte) (err error) {
+	return m.FromString(string(data))
+}
+
+func (m *Matcher) Matches(alert *Alert) bool {
+	value := alert.LabelValue(m.Name)
+	switch m.Type {
+	case MatchEqual:
+		return value == m.Value
+	case MatchNotEqual:
+		return value != m.Value
+	case MatchRegexp:
+		return m.re.MatchString(value)
+	case MatchNotRegexp:
+		return !m.re.MatchString(value)
+	}
+	return false
+}
+
+type Matchers []Matcher
+
+// Matches reports whether all matchers match the alert. Empty matchers match every alert.
+func (ms Matchers) Matches(alert *Alert) bool {
+	for idx := range ms {
+		if !ms[idx].Matches(alert) {
+			return false
+		}
+	}
+	return true
+}
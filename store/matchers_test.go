@@ -0,0 +1,141 @@
+//
+// Copyright (c) 2023 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.alerts nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package store
+
+import (
+	"testing"
+)
+
+func TestMatcherFromString(t *testing.T) {
+	testVectors := []struct {
+		str      string
+		valid    bool
+		name     string
+		typ      MatchType
+		value    string
+		expected string
+	}{
+		{`team="db"`, true, "team", MatchEqual, "db", `team="db"`},
+		{`team=db`, true, "team", MatchEqual, "db", `team="db"`},
+		{`  team = db  `, true, "team", MatchEqual, "db", `team="db"`},
+		{`severity!=informational`, true, "severity", MatchNotEqual, "informational", `severity!="informational"`},
+		{`instance=~"db[0-9]+"`, true, "instance", MatchRegexp, "db[0-9]+", `instance=~"db[0-9]+"`},
+		{`instance!~db.*`, true, "instance", MatchNotRegexp, "db.*", `instance!~"db.*"`},
+		{`app.kubernetes.io/name=x`, false, "", MatchEqual, "", ""},
+		{`app.kubernetes-io_name=x`, true, "app.kubernetes-io_name", MatchEqual, "x", `app.kubernetes-io_name="x"`},
+		{`team=""`, true, "team", MatchEqual, "", `team=""`},
+		{`team=`, true, "team", MatchEqual, "", `team=""`},
+		{`msg="a \"quoted\" value"`, true, "msg", MatchEqual, `a "quoted" value`, `msg="a \"quoted\" value"`},
+		{`msg="broken \"`, false, "", MatchEqual, "", ""},
+		{`instance=~"db[0-9"`, false, "", MatchEqual, "", ""},
+		{`=db`, false, "", MatchEqual, "", ""},
+		{`1team=db`, false, "", MatchEqual, "", ""},
+		{`team`, false, "", MatchEqual, "", ""},
+		{``, false, "", MatchEqual, "", ""},
+	}
+	for _, vector := range testVectors {
+		var m Matcher
+		err := m.FromString(vector.str)
+		if !vector.valid {
+			if err == nil {
+				t.Errorf("'%s': should be rejected but got %s", vector.str, m)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("'%s': unexpected error: %v", vector.str, err)
+			continue
+		}
+		if m.Name != vector.name || m.Type != vector.typ || m.Value != vector.value {
+			t.Errorf("'%s': got name=%q type=%s value=%q", vector.str, m.Name, m.Type, m.Value)
+		}
+		if m.String() != vector.expected {
+			t.Errorf("'%s': expected string %s, got %s", vector.str, vector.expected, m.String())
+		}
+	}
+}
+
+func TestMatcherMatches(t *testing.T) {
+	alert := &Alert{Name: "DiskFull", Severity: SeverityWarning, Labels: map[string]string{"instance": "db12", "team": "db"}}
+	testVectors := []struct {
+		matcher string
+		matches bool
+	}{
+		{`team=db`, true},
+		{`team=dba`, false},
+		{`team!=db`, false},
+		{`team!=web`, true},
+		{`missing=""`, true},
+		{`missing!=""`, false},
+		{`alertname=DiskFull`, true},
+		{`severity=warning`, true},
+		{`severity=~"critical|warning"`, true},
+		{`severity!~"critical|warning"`, false},
+		{`instance=~"db[0-9]+"`, true},
+		// regular expressions are anchored and must match the whole value
+		{`instance=~"db"`, false},
+		{`instance=~"b1"`, false},
+		{`instance=~"db1"`, false},
+		{`instance=~"^db12$"`, true},
+		{`instance!~"db"`, true},
+		{`instance=~"web|db12"`, true},
+		{`instance=~"db1|eb12"`, false},
+		{`missing=~".*"`, true},
+		{`missing=~".+"`, false},
+	}
+	for _, vector := range testVectors {
+		var m Matcher
+		if err := m.FromString(vector.matcher); err != nil {
+			t.Fatalf("'%s': unexpected error: %v", vector.matcher, err)
+		}
+		if result := m.Matches(alert); result != vector.matches {
+			t.Errorf("'%s': expected %t, got %t", vector.matcher, vector.matches, result)
+		}
+	}
+
+	var ms Matchers
+	if !ms.Matches(alert) {
+		t.Errorf("empty matchers must match every alert")
+	}
+	for _, str := range []string{`team=db`, `instance=~"db.*"`} {
+		var m Matcher
+		m.FromString(str)
+		ms = append(ms, m)
+	}
+	if !ms.Matches(alert) {
+		t.Errorf("%v: expected all matchers to match", ms)
+	}
+	var m Matcher
+	m.FromString(`severity=critical`)
+	if ms = append(ms, m); ms.Matches(alert) {
+		t.Errorf("%v: expected matchers not to match", ms)
+	}
+}
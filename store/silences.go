@@ -0,0 +1,123 @@
+//
+// Copyright (c) 2023 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.alerts nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package store
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+	bolt "go.etcd.io/bbolt"
+)
+
+// The state of silences is derived from their start and end time whenever they are read
+// from the store. Expired silences are therefore automatically deactivated.
+
+func (s *Store) CreateSilence(silence *Silence) (*Silence, error) {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		silence.ID = ulid.Make().String()
+		silence.CreatedAt = time.Now()
+		silence.UpdatedAt = silence.CreatedAt
+		silence.State = silence.StateAt(silence.CreatedAt)
+		return putJSON(tx.Bucket(bucketSilences), silence.ID, silence)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return silence, nil
+}
+
+func (s *Store) ListSilences(offset, limit int) (silences []Silence, err error) {
+	offset, limit = normalizePagination(offset, limit)
+	silences = []Silence{}
+	err = s.db.View(func(tx *bolt.Tx) error {
+		now := time.Now()
+		c := tx.Bucket(bucketSilences).Cursor()
+		idx := 0
+		for k, v := c.First(); k != nil && len(silences) < limit; k, v = c.Next() {
+			if idx < offset {
+				idx++
+				continue
+			}
+			var silence Silence
+			if err := json.Unmarshal(v, &silence); err != nil {
+				return err
+			}
+			silence.State = silence.StateAt(now)
+			silences = append(silences, silence)
+		}
+		return nil
+	})
+	return
+}
+
+func (s *Store) GetSilence(id string) (silence *Silence, err error) {
+	silence = &Silence{}
+	err = s.db.View(func(tx *bolt.Tx) error {
+		return getJSON(tx.Bucket(bucketSilences), id, silence)
+	})
+	if err != nil {
+		return nil, err
+	}
+	silence.State = silence.StateAt(time.Now())
+	return
+}
+
+func (s *Store) UpdateSilence(id string, update *Silence) (silence *Silence, err error) {
+	silence = &Silence{}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketSilences)
+		if err := getJSON(b, id, silence); err != nil {
+			return err
+		}
+		silence.Matchers = update.Matchers
+		silence.StartsAt = update.StartsAt
+		silence.EndsAt = update.EndsAt
+		silence.Comment = update.Comment
+		silence.UpdatedAt = time.Now()
+		silence.State = silence.StateAt(silence.UpdatedAt)
+		return putJSON(b, id, silence)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+func (s *Store) DeleteSilence(id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketSilences)
+		if b.Get([]byte(id)) == nil {
+			return ErrNotFound
+		}
+		return b.Delete([]byte(id))
+	})
+}
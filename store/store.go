@@ -31,12 +31,24 @@
 package store
 
 import (
+	"encoding/binary"
+	"encoding/json"
 	"io"
 	"log"
 
 	bolt "go.etcd.io/bbolt"
 )
 
+var (
+	bucketAlerts       = []byte("alerts")
+	bucketAlertHistory = []byte("alert-history")
+	bucketFingerprints = []byte("alert-fingerprints")
+	bucketDeliveries   = []byte("alert-deliveries")
+	bucketHeartbeats   = []byte("heartbeats")
+	bucketSilences     = []byte("silences")
+	bucketRateLimits   = []byte("rate-limits")
+)
+
 type Store struct {
 	conf    *Config
 	db      *bolt.DB
@@ -53,11 +65,62 @@ func Open(conf *Config, infoLog, dbgLog *log.Logger) (s *Store, err error) {
 	}
 
 	s = &Store{conf: conf, infoLog: infoLog, dbgLog: dbgLog}
-	s.db, err = bolt.Open(conf.Path, 0600, nil)
+	if s.db, err = bolt.Open(conf.Path, 0600, nil); err != nil {
+		return
+	}
+	if err = s.init(); err != nil {
+		s.db.Close()
+		return
+	}
 	infoLog.Printf("store: opened database %s", s.conf.Path)
 	return
 }
 
+func (s *Store) init() error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketAlerts, bucketAlertHistory, bucketFingerprints, bucketDeliveries, bucketHeartbeats, bucketSilences, bucketRateLimits} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
 func (s *Store) Close() error {
 	return s.db.Close()
 }
+
+// Utils
+
+func getJSON(b *bolt.Bucket, key string, v interface{}) error {
+	data := b.Get([]byte(key))
+	if data == nil {
+		return ErrNotFound
+	}
+	return json.Unmarshal(data, v)
+}
+
+func putJSON(b *bolt.Bucket, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(key), data)
+}
+
+func sequenceKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
+
+func normalizePagination(offset, limit int) (int, int) {
+	if offset < 0 {
+		offset = 0
+	}
+	if limit < 0 {
+		limit = int(^uint(0) >> 1)
+	}
+	return offset, limit
+}
@@ -31,8 +31,12 @@
 package store
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/enescakir/emoji"
@@ -41,14 +45,17 @@ import (
 // Configuration
 
 type Config struct {
-	Path string `yaml:"path"`
+	Path         string `yaml:"path"`
+	ReopenClosed bool   `yaml:"reopenClosed"`
 }
 
 // Errors
 
 var (
-	ErrNotImplemented = errors.New("not implemented")
-	ErrNotFound       = errors.New("not found")
+	ErrNotImplemented  = errors.New("not implemented")
+	ErrNotFound        = errors.New("not found")
+	ErrShortIDTooShort = errors.New("alert ID is too short")
+	ErrAmbiguousID     = errors.New("alert ID is ambiguous")
 )
 
 type ErrInvalidStateTransition struct {
@@ -122,6 +129,25 @@ func (s AlertState) Emoji() emoji.Emoji {
 	return emoji.WhiteQuestionMark
 }
 
+var alertStateTransitions = map[AlertState][]AlertState{
+	StateNew:          {StateOpen, StateAcknowledged, StateStale, StateClosed},
+	StateOpen:         {StateAcknowledged, StateStale, StateClosed},
+	StateAcknowledged: {StateOpen, StateStale, StateClosed},
+	StateStale:        {StateOpen, StateAcknowledged, StateClosed},
+	StateClosed:       {StateOpen},
+}
+
+// CanTransitionTo reports whether an alert in state s may be moved to state new.
+// Alerts never go back to StateNew and closed alerts can only be re-opened.
+func (s AlertState) CanTransitionTo(new AlertState) bool {
+	for _, allowed := range alertStateTransitions[s] {
+		if allowed == new {
+			return true
+		}
+	}
+	return false
+}
+
 func (s AlertState) MarshalText() (data []byte, err error) {
 	data = []byte(s.String())
 	return
@@ -187,24 +213,341 @@ func (s *AlertSeverity) UnmarshalText(data []byte) (err error) {
 }
 
 type Alert struct {
-	ID        string        `json:"id"`
-	CreatedAt time.Time     `json:"created"`
-	UpdatedAt time.Time     `json:"updated"`
-	Name      string        `json:"name"`
-	State     AlertState    `json:"state"`
-	Severity  AlertSeverity `json:"severity"`
-	// TODO: additinial fields
+	ID          string            `json:"id"`
+	CreatedAt   time.Time         `json:"created"`
+	UpdatedAt   time.Time         `json:"updated"`
+	Name        string            `json:"name"`
+	State       AlertState        `json:"state"`
+	Severity    AlertSeverity     `json:"severity"`
+	Source      string            `json:"source,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Fingerprint string            `json:"fingerprint"`
+	Occurrences uint              `json:"occurrences"`
+	ActivatedAt time.Time         `json:"activated"`
+	NotifiedAt  *time.Time        `json:"notified,omitempty"`
+	Escalation  map[string]int    `json:"escalation,omitempty"`
 }
 
 func (a Alert) String() string {
 	return a.ID
 }
 
+// LabelValue returns the value of the label with the given name. The names alertname and
+// severity refer to the name and the severity of the alert.
+func (a Alert) LabelValue(name string) string {
+	switch name {
+	case "alertname":
+		return a.Name
+	case "severity":
+		return a.Severity.String()
+	}
+	return a.Labels[name]
+}
+
+const (
+	ShortIDLength = 6
+)
+
+// ShortID returns the last characters of the alert ID. This is meant to be used in places
+// where people need to type the ID, e.g. replies to notifications.
+func (a Alert) ShortID() string {
+	if len(a.ID) < ShortIDLength {
+		return strings.ToLower(a.ID)
+	}
+	return strings.ToLower(a.ID[len(a.ID)-ShortIDLength:])
+}
+
+// ComputeFingerprint returns a hash over the name and the labels of the alert. Alerts
+// with the same fingerprint are considered to describe the same problem.
+func (a Alert) ComputeFingerprint() string {
+	names := make([]string, 0, len(a.Labels))
+	for name := range a.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	h.Write([]byte(a.Name))
+	for _, name := range names {
+		h.Write([]byte{0})
+		h.Write([]byte(name))
+		h.Write([]byte{0})
+		h.Write([]byte(a.Labels[name]))
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// Alert History
+
+type StateChangeSource uint
+
+const (
+	SourceAPI StateChangeSource = iota
+	SourceSMS
+	SourceAutomatic
+	SourceMatrix
+	SourceTelegram
+	SourceXMPP
+)
+
+func (s StateChangeSource) String() string {
+	switch s {
+	case SourceAPI:
+		return "api"
+	case SourceSMS:
+		return "sms"
+	case SourceAutomatic:
+		return "automatic"
+	case SourceMatrix:
+		return "matrix"
+	case SourceTelegram:
+		return "telegram"
+	case SourceXMPP:
+		return "xmpp"
+	}
+	return "unknown"
+}
+
+func (s *StateChangeSource) FromString(str string) error {
+	switch str {
+	case "api":
+		*s = SourceAPI
+	case "sms":
+		*s = SourceSMS
+	case "automatic":
+		*s = SourceAutomatic
+	case "matrix":
+		*s = SourceMatrix
+	case "telegram":
+		*s = SourceTelegram
+	case "xmpp":
+		*s = SourceXMPP
+	default:
+		return errors.New("invalid state change source: '" + str + "'")
+	}
+	return nil
+}
+
+func (s StateChangeSource) MarshalText() (data []byte, err error) {
+	data = []byte(s.String())
+	return
+}
+
+func (s *StateChangeSource) UnmarshalText(data []byte) (err error) {
+	return s.FromString(string(data))
+}
+
+type AlertStateChange struct {
+	Timestamp time.Time         `json:"timestamp"`
+	Old       AlertState        `json:"old"`
+	New       AlertState        `json:"new"`
+	Actor     string            `json:"actor"`
+	Source    StateChangeSource `json:"source"`
+}
+
+type DeliveryOutcome uint
+
+const (
+	DeliverySent DeliveryOutcome = iota
+	DeliveryFailed
+	DeliverySuppressed
+)
+
+func (o DeliveryOutcome) String() string {
+	switch o {
+	case DeliverySent:
+		return "sent"
+	case DeliveryFailed:
+		return "failed"
+	case DeliverySuppressed:
+		return "suppressed"
+	}
+	return "unknown"
+}
+
+func (o *DeliveryOutcome) FromString(str string) error {
+	switch str {
+	case "sent":
+		*o = DeliverySent
+	case "failed":
+		*o = DeliveryFailed
+	case "suppressed":
+		*o = DeliverySuppressed
+	default:
+		return errors.New("invalid delivery outcome: '" + str + "'")
+	}
+	return nil
+}
+
+func (o DeliveryOutcome) MarshalText() (data []byte, err error) {
+	data = []byte(o.String())
+	return
+}
+
+func (o *DeliveryOutcome) UnmarshalText(data []byte) (err error) {
+	return o.FromString(string(data))
+}
+
+// Delivery records an attempt to notify a target about an alert via a backend.
+type Delivery struct {
+	Timestamp time.Time       `json:"timestamp"`
+	Alert     string          `json:"alert"`
+	Target    string          `json:"target"`
+	Backend   string          `json:"backend"`
+	Attempt   uint            `json:"attempt"`
+	Outcome   DeliveryOutcome `json:"outcome"`
+	Error     string          `json:"error,omitempty"`
+}
+
 // Heartbeats
 
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d Duration) MarshalText() (data []byte, err error) {
+	data = []byte(d.String())
+	return
+}
+
+func (d *Duration) UnmarshalText(data []byte) (err error) {
+	var value time.Duration
+	if value, err = time.ParseDuration(string(data)); err != nil {
+		return
+	}
+	*d = Duration(value)
+	return
+}
+
 type Heartbeat struct {
-	ID        string    `json:"id"`
-	CreatedAt time.Time `json:"created"`
-	UpdatedAt time.Time `json:"updated"`
-	// TODO: additinial fields
+	ID          string        `json:"id"`
+	CreatedAt   time.Time     `json:"created"`
+	UpdatedAt   time.Time     `json:"updated"`
+	Name        string        `json:"name"`
+	Interval    Duration      `json:"interval"`
+	GracePeriod Duration      `json:"gracePeriod"`
+	Severity    AlertSeverity `json:"severity"`
+	RefreshedAt *time.Time    `json:"refreshed,omitempty"`
+}
+
+func (h Heartbeat) String() string {
+	return h.ID
+}
+
+// Deadline returns the time by which the next refresh is expected to happen.
+func (h Heartbeat) Deadline() time.Time {
+	last := h.CreatedAt
+	if h.RefreshedAt != nil {
+		last = *h.RefreshedAt
+	}
+	return last.Add(time.Duration(h.Interval) + time.Duration(h.GracePeriod))
+}
+
+// Alert returns the alert which is raised if the heartbeat misses its deadline.
+func (h Heartbeat) Alert() *Alert {
+	return &Alert{
+		Name:        "heartbeat '" + h.Name + "' missed its deadline",
+		State:       StateNew,
+		Severity:    h.Severity,
+		Source:      "heartbeat",
+		Description: fmt.Sprintf("no refresh for heartbeat '%s' within %s (grace period: %s)", h.Name, h.Interval, h.GracePeriod),
+		Labels:      map[string]string{"heartbeat": h.ID},
+	}
+}
+
+// Silences
+
+type SilenceState uint
+
+const (
+	SilencePending SilenceState = iota
+	SilenceActive
+	SilenceExpired
+)
+
+func (s SilenceState) String() string {
+	switch s {
+	case SilencePending:
+		return "pending"
+	case SilenceActive:
+		return "active"
+	case SilenceExpired:
+		return "expired"
+	}
+	return "unknown"
+}
+
+func (s *SilenceState) FromString(str string) error {
+	switch str {
+	case "pending":
+		*s = SilencePending
+	case "active":
+		*s = SilenceActive
+	case "expired":
+		*s = SilenceExpired
+	default:
+		return errors.New("invalid silence state: '" + str + "'")
+	}
+	return nil
+}
+
+func (s SilenceState) MarshalText() (data []byte, err error) {
+	data = []byte(s.String())
+	return
+}
+
+func (s *SilenceState) UnmarshalText(data []byte) (err error) {
+	return s.FromString(string(data))
+}
+
+type Silence struct {
+	ID        string       `json:"id"`
+	CreatedAt time.Time    `json:"created"`
+	UpdatedAt time.Time    `json:"updated"`
+	Matchers  Matchers     `json:"matchers"`
+	StartsAt  time.Time    `json:"startsAt"`
+	EndsAt    time.Time    `json:"endsAt"`
+	CreatedBy string       `json:"createdBy"`
+	Comment   string       `json:"comment"`
+	State     SilenceState `json:"state"`
+}
+
+func (s Silence) String() string {
+	return s.ID
+}
+
+func (s Silence) StateAt(t time.Time) SilenceState {
+	if t.Before(s.StartsAt) {
+		return SilencePending
+	}
+	if t.Before(s.EndsAt) {
+		return SilenceActive
+	}
+	return SilenceExpired
+}
+
+// Silences returns true if the silence is active at time t and matches the alert.
+func (s Silence) Silences(alert *Alert, t time.Time) bool {
+	return s.StateAt(t) == SilenceActive && s.Matchers.Matches(alert)
+}
+
+// Rate Limits
+
+// RateLimitSuppressed counts the alerts which have been held back by a rate limiter and have
+// not been reported to Target via Backend yet.
+type RateLimitSuppressed struct {
+	Target  string `json:"target"`
+	Backend string `json:"backend"`
+	Alerts  uint   `json:"alerts"`
+}
+
+// RateLimit is the persistent state of a token bucket rate limiter.
+type RateLimit struct {
+	Tokens     float64               `json:"tokens"`
+	UpdatedAt  time.Time             `json:"updated"`
+	Suppressed []RateLimitSuppressed `json:"suppressed,omitempty"`
 }
@@ -0,0 +1,89 @@
+//
+// Copyright (c) 2023 whawty contributors (see AUTHORS file)
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// * Neither the name of whawty.alerts nor the names of its
+//   contributors may be used to endorse or promote products derived from
+//   this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+
+package store
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestAlertStateCanTransitionTo(t *testing.T) {
+	states := []AlertState{StateNew, StateOpen, StateAcknowledged, StateStale, StateClosed}
+	// rows are the old states, columns the new states in the order of states
+	testVectors := map[AlertState][]bool{
+		StateNew:          {false, true, true, true, true},
+		StateOpen:         {false, false, true, true, true},
+		StateAcknowledged: {false, true, false, true, true},
+		StateStale:        {false, true, true, false, true},
+		StateClosed:       {false, true, false, false, false},
+	}
+	for old, expected := range testVectors {
+		for idx, new := range states {
+			if result := old.CanTransitionTo(new); result != expected[idx] {
+				t.Errorf("%s -> %s: expected %t, got %t", old, new, expected[idx], result)
+			}
+		}
+	}
+	if AlertState(42).CanTransitionTo(StateOpen) {
+		t.Errorf("unknown states must not transition to anything")
+	}
+}
+
+func TestSetAlertState(t *testing.T) {
+	s := openTestStore(t)
+	alert, _, err := s.CreateAlert(&Alert{Name: "test"})
+	if err != nil {
+		t.Fatalf("failed to create alert: %v", err)
+	}
+
+	if alert, err = s.SetAlertState(alert.ID, StateClosed, "hugo", SourceAPI); err != nil {
+		t.Fatalf("failed to close alert: %v", err)
+	}
+	if alert.State != StateClosed {
+		t.Fatalf("expected alert to be closed, got %s", alert.State)
+	}
+
+	_, err = s.SetAlertState(alert.ID, StateAcknowledged, "hugo", SourceAPI)
+	var transitionErr ErrInvalidStateTransition
+	if !errors.As(err, &transitionErr) {
+		t.Fatalf("expected invalid state transition error, got %v", err)
+	}
+	if alert, err = s.GetAlert(alert.ID); err != nil {
+		t.Fatalf("failed to get alert: %v", err)
+	}
+	if alert.State != StateClosed {
+		t.Fatalf("rejected state transition changed alert to %s", alert.State)
+	}
+
+	if _, err = s.SetAlertState("does-not-exist", StateOpen, "hugo", SourceAPI); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound for unknown alert, got %v", err)
+	}
+}